@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// accessLogger writes a line for every request the origin proxy handles. Request bodies are never logged.
+type accessLogger struct {
+	out    io.WriteCloser
+	format string
+	lock   sync.Mutex
+}
+
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMs float64   `json:"latency_ms"`
+	CFRay     string    `json:"cf_ray,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// newAccessLogger returns nil if --access-log isn't set. "-" logs to stdout.
+func newAccessLogger(c *cli.Context) (*accessLogger, error) {
+	path := c.String("access-log")
+	if path == "" {
+		return nil, nil
+	}
+	format := c.String("access-log-format")
+	if format != "combined" && format != "json" {
+		return nil, errors.Errorf("invalid --access-log-format %q, expected combined or json", format)
+	}
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open --access-log")
+		}
+		out = file
+	}
+	return &accessLogger{out: out, format: format}, nil
+}
+
+func (l *accessLogger) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		remote := r.Header.Get("CF-Connecting-IP")
+		if remote == "" {
+			remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		l.write(&accessLogEntry{
+			Time:      start,
+			Remote:    remote,
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			CFRay:     r.Header.Get("CF-Ray"),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+	})
+}
+
+func (l *accessLogger) write(entry *accessLogEntry) {
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(entry)
+	} else {
+		line = []byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %s %s %.3f %s",
+			entry.Remote, entry.Time.Format("02/Jan/2006:15:04:05 -0700"), entry.Method, entry.Path, entry.Proto,
+			entry.Status, entry.Bytes, strconv.Quote(dashIfEmpty(entry.Referer)), strconv.Quote(dashIfEmpty(entry.UserAgent)),
+			entry.LatencyMs, dashIfEmpty(entry.CFRay)))
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *accessLogger) Close() error {
+	return l.out.Close()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// statusRecorder captures the status and size of a response. It passes through flushing and hijacking,
+// which the reverse proxy needs for streamed responses and websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Hostname sent by the callback-test command in place of a real tunnel hostname.
+const callbackTestHostname = "callback-test.trycloudflare.com"
+
+// CallbackNotifier tells a receiver which hostname the tunnel is reachable at.
+type CallbackNotifier struct {
+	Target          string
+	timeout         time.Duration
+	maxRetries      int
+	followRedirects bool
+	client          *http.Client
+	payload         *callbackPayload
+	success         statusMatcher
+	token           *callbackToken
+	signer          *callbackSigner
+}
+
+// Maximum number of redirects followed with --callback-follow-redirects.
+const maxCallbackRedirects = 10
+
+func newCallbackNotifier(c *cli.Context, target string, client *http.Client) *CallbackNotifier {
+	return &CallbackNotifier{
+		Target:          target,
+		timeout:         c.Duration("callback-timeout"),
+		maxRetries:      c.Int("callback-retry-max"),
+		followRedirects: c.Bool("callback-follow-redirects"),
+		client:          client,
+		payload:         &callbackPayload{contentType: "text/plain"},
+		success:         statusMatcher{{min: 200, max: 299}},
+	}
+}
+
+// newCallbackClient is the client for the --callback notifiers. With --callback-tls-insecure it skips certificate
+// verification, which the summary webhook, the shutdown callback and the quick-service never do.
+func newCallbackClient(c *cli.Context) *http.Client {
+	transport := outboundTransport(c)
+	if c.Bool("callback-tls-insecure") {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return noRedirectClient(transport)
+}
+
+// noRedirectClient doesn't follow redirects itself: it would turn most of them into a GET without the body.
+func noRedirectClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Timeout:   httpTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// callbackTarget resolves a callback, which is either an absolute URL or a path relative to the origin URL.
+func callbackTarget(originURL, callback string) (string, error) {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid callback %q", callback)
+	}
+	if u.IsAbs() {
+		return callback, nil
+	}
+	if !strings.HasPrefix(originURL, "http://") && !strings.HasPrefix(originURL, "https://") {
+		return "", errors.Errorf("callback %q must be an absolute URL when --url is not an http(s) origin", callback)
+	}
+	return fmt.Sprintf("%s/%s", originURL, callback), nil
+}
+
+// Post sends a single notification and returns the receiver's response along with its body.
+func (n *CallbackNotifier) Post(config *QuickTunnelConfig) (*http.Response, []byte, error) {
+	contentType, payload, err := n.payload.render(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n.post(contentType, payload)
+}
+
+// post sends the payload, and with --callback-follow-redirects sends it again to wherever it is redirected.
+func (n *CallbackNotifier) post(contentType string, payload []byte) (*http.Response, []byte, error) {
+	target := n.Target
+	for redirects := 0; ; redirects++ {
+		resp, body, err := n.postTo(target, contentType, payload)
+		if err != nil || !n.followRedirects || !isRedirect(resp.StatusCode) || n.success.Match(resp.StatusCode) {
+			return resp, body, err
+		}
+		if redirects == maxCallbackRedirects {
+			return nil, nil, errors.Errorf("stopped after %d redirects", maxCallbackRedirects)
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return resp, body, errors.Wrap(err, "invalid redirect")
+		}
+		target = location.String()
+	}
+}
+
+func (n *CallbackNotifier) postTo(target, contentType string, payload []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	n.token.apply(req)
+	n.signer.apply(req, payload)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+	return resp, body, nil
+}
+
+func isRedirect(status int) bool {
+	return status >= 300 && status <= 399
+}
+
+// Notify posts the tunnel's hostname, or the rendered --callback-payload-template, to the receiver,
+// retrying with exponential backoff until it succeeds, the callback timeout has elapsed or --callback-retry-max
+// retries have been made. It returns the body of the successful response.
+func (n *CallbackNotifier) Notify(config *QuickTunnelConfig) ([]byte, error) {
+	contentType, payload, err := n.payload.render(config)
+	if err != nil {
+		return nil, &ErrCallbackFailed{Target: n.Target, Err: err}
+	}
+	return n.notify(contentType, payload)
+}
+
+func (n *CallbackNotifier) notify(contentType string, payload []byte) ([]byte, error) {
+	var body []byte
+	attempts := 0
+	permanent := false
+	callbackOperation := func() error {
+		attempts++
+		resp, respBody, err := n.post(contentType, payload)
+		if err != nil {
+			return err
+		}
+		if n.success.Match(resp.StatusCode) {
+			body = respBody
+			return nil
+		}
+		if isRedirect(resp.StatusCode) {
+			// Retrying won't change where the receiver redirects to
+			permanent = true
+			return backoff.Permanent(errors.Errorf("Callback redirected with %s to %q, use that URL as the callback or set --callback-follow-redirects", resp.Status, resp.Header.Get("Location")))
+		}
+		return errors.Errorf("Callback error: %s", resp.Status)
+	}
+	exponential := backoff.NewExponentialBackOff()
+	exponential.MaxElapsedTime = n.timeout
+	var retryPolicy backoff.BackOff = exponential
+	if n.maxRetries > 0 {
+		retryPolicy = backoff.WithMaxRetries(exponential, uint64(n.maxRetries))
+	}
+	if err := backoff.Retry(callbackOperation, retryPolicy); err != nil {
+		if !permanent {
+			err = errors.Wrap(err, n.stopReason(attempts))
+		}
+		return nil, &ErrCallbackFailed{Target: n.Target, Err: err}
+	}
+	return body, nil
+}
+
+// stopReason tells whether retrying stopped because of --callback-retry-max or --callback-timeout.
+func (n *CallbackNotifier) stopReason(attempts int) string {
+	if n.maxRetries > 0 && attempts > n.maxRetries {
+		return fmt.Sprintf("gave up after %d attempts, --callback-retry-max %d", attempts, n.maxRetries)
+	}
+	return fmt.Sprintf("gave up after %d attempts, --callback-timeout %s elapsed", attempts, n.timeout)
+}
+
+// CallbackGroup notifies every configured callback concurrently.
+type CallbackGroup struct {
+	notifiers []*CallbackNotifier
+	quorum    int
+	log       *zerolog.Logger
+	metrics   MetricsRecorder
+	token     *callbackToken
+	once      *callbackOnceFile
+}
+
+func NewCallbackGroup(c *cli.Context, log *zerolog.Logger, metrics MetricsRecorder) (*CallbackGroup, error) {
+	callbacks := c.StringSlice("callback")
+	quorum := c.Int("callback-quorum")
+	if quorum < 1 || (len(callbacks) > 0 && quorum > len(callbacks)) {
+		return nil, errors.Errorf("--callback-quorum must be between 1 and the number of callbacks (%d)", len(callbacks))
+	}
+	payload, err := newCallbackPayload(c)
+	if err != nil {
+		return nil, err
+	}
+	success, err := parseStatusMatcher(c.String("callback-success-codes"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --callback-success-codes")
+	}
+	if payload.includeCredentials {
+		if c.Bool("callback-follow-redirects") {
+			return nil, errors.New("--callback-include-credentials can't be used with --callback-follow-redirects, a redirect would send the credentials on")
+		}
+		log.Warn().Msg("--callback-include-credentials is set: callbacks receive the tunnel secret, anyone who gets it can run this tunnel")
+	}
+	if c.Bool("callback-tls-insecure") && len(callbacks) > 0 {
+		log.Warn().Msg("--callback-tls-insecure is set: any certificate presented by a callback receiver will be accepted")
+	}
+	group := &CallbackGroup{quorum: quorum, log: log, metrics: metrics, token: newCallbackToken(c), once: newCallbackOnceFile(c)}
+	client := newCallbackClient(c)
+	signer := newCallbackSigner(c)
+	for _, callback := range callbacks {
+		target, err := callbackTarget(c.String("url"), callback)
+		if err != nil {
+			return nil, err
+		}
+		if u, _ := url.Parse(target); payload.includeCredentials && u.Scheme != "https" {
+			return nil, errors.Errorf("--callback-include-credentials only sends credentials over https, refusing callback %s", target)
+		}
+		notifier := newCallbackNotifier(c, target, client)
+		notifier.payload = payload
+		notifier.success = success
+		notifier.token = group.token
+		notifier.signer = signer
+		group.notifiers = append(group.notifiers, notifier)
+	}
+	return group, nil
+}
+
+func (g *CallbackGroup) Empty() bool {
+	return len(g.notifiers) == 0
+}
+
+// Notify notifies every callback of the tunnel at once. It fails if fewer than the quorum of them succeed.
+// With --callback-token-header the first token a receiver answers with is stored in config, and with
+// --callback-once-file a URL that was already notified of is skipped.
+func (g *CallbackGroup) Notify(config *QuickTunnelConfig) error {
+	if g.once.Done(config.URL) {
+		g.log.Info().Msgf("Callbacks were already notified of %s, skipping them as --callback-once-file is set", config.URL)
+		return nil
+	}
+	errs := make([]error, len(g.notifiers))
+	bodies := make([][]byte, len(g.notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range g.notifiers {
+		wg.Add(1)
+		go func(i int, notifier *CallbackNotifier) {
+			defer wg.Done()
+			bodies[i], errs[i] = notifier.Notify(config)
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			g.log.Error().Msg(err.Error())
+			failed = append(failed, g.notifiers[i].Target)
+			g.metrics.AddCounter(metricCallbackNotifications, 1, map[string]string{"result": "failure"})
+		} else {
+			g.metrics.AddCounter(metricCallbackNotifications, 1, map[string]string{"result": "success"})
+		}
+	}
+	if succeeded := len(g.notifiers) - len(failed); succeeded < g.quorum {
+		return &ErrCallbackFailed{
+			Target: strings.Join(failed, ", "),
+			Err:    errors.Errorf("%d of %d callbacks succeeded, %d required", succeeded, len(g.notifiers), g.quorum),
+		}
+	}
+	for i, body := range bodies {
+		if errs[i] == nil && g.token.Update(body, g.log) {
+			break
+		}
+	}
+	config.CallbackToken = g.token.Get()
+	if err := g.once.Record(config.URL); err != nil {
+		g.log.Err(err).Msg("Failed to record the notified URL")
+	}
+	return nil
+}
+
+// CallbackTest sends a sample notification to each callback without creating a tunnel and prints the responses.
+func CallbackTest(c *cli.Context) error {
+	log := createLogger(c, false)
+	group, err := NewCallbackGroup(c, log, nopMetrics{})
+	if err != nil {
+		return err
+	}
+	if group.Empty() {
+		return errors.New("--callback is required")
+	}
+	failed := 0
+	for _, notifier := range group.notifiers {
+		fmt.Printf("POST %s\n", notifier.Target)
+		resp, body, err := notifier.Post(&QuickTunnelConfig{URL: callbackTestHostname})
+		if err != nil {
+			fmt.Printf("callback request failed: %v\n\n", err)
+			failed++
+			continue
+		}
+		fmt.Println(resp.Status)
+		fmt.Printf("%s\n\n", body)
+		if !notifier.success.Match(resp.StatusCode) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d callbacks failed", failed, len(group.notifiers)), 1)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// delayedCallbacks notifies the callbacks of a new tunnel --callback-delay after its first connection is
+// registered, instead of before the tunnel starts, so receivers that probe the URL straight away can reach it.
+// The credentials are already stored by then, so if the callbacks fail they are removed again and the tunnel
+// shuts down, which leaves a restart to create a tunnel and notify of it as usual. A graceful shutdown during
+// the delay is not a failure: the credentials are kept and, with --callback-once-file, the callbacks are
+// notified of the stored tunnel on the next start.
+type delayedCallbacks struct {
+	callbacks *CallbackGroup
+	config    *QuickTunnelConfig
+	delay     time.Duration
+	// Empty with --readonly-credentials, when nothing was stored
+	credentials      string
+	credentialsCodec credentialsCodec
+	progress         *progressStream
+	log              *zerolog.Logger
+	graceShutdownC   chan struct{}
+
+	once sync.Once
+	lock sync.Mutex
+	err  error
+}
+
+func newDelayedCallbacks(c *cli.Context, callbacks *CallbackGroup, config *QuickTunnelConfig, codec credentialsCodec, progress *progressStream, log *zerolog.Logger, graceShutdownC chan struct{}) *delayedCallbacks {
+	delayed := &delayedCallbacks{
+		callbacks:        callbacks,
+		config:           config,
+		delay:            c.Duration("callback-delay"),
+		credentialsCodec: codec,
+		progress:         progress,
+		log:              log,
+		graceShutdownC:   graceShutdownC,
+	}
+	if !c.Bool("readonly-credentials") {
+		delayed.credentials = c.String("credentials")
+	}
+	return delayed
+}
+
+// Run is a zerolog hook that starts the countdown on the first registered connection.
+func (d *delayedCallbacks) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if connectionRegistered.MatchString(msg) {
+		d.once.Do(func() {
+			go d.notify()
+		})
+	}
+}
+
+func (d *delayedCallbacks) notify() {
+	d.log.Info().Msgf("Tunnel connected, notifying server of changed tunnel in %s", d.delay)
+	select {
+	case <-time.After(d.delay):
+	case <-d.graceShutdownC:
+		d.log.Warn().Msg("Tunnel shut down before the callbacks were notified")
+		return
+	}
+	token := d.config.CallbackToken
+	if err := d.callbacks.Notify(d.config); err != nil {
+		d.log.Error().Msg(err.Error())
+		d.fail(err)
+		requestShutdown(d.graceShutdownC)
+		return
+	}
+	d.progress.Emit(phaseCallbackSent)
+	if d.config.CallbackToken != token && d.credentials != "" {
+		if err := WriteQuickTunnelConfig(d.credentials, d.credentialsCodec, d.config); err != nil {
+			d.log.Err(err).Msg("Failed to store the callback token")
+		}
+	}
+}
+
+func (d *delayedCallbacks) fail(err error) {
+	d.lock.Lock()
+	d.err = err
+	d.lock.Unlock()
+	if d.credentials == "" {
+		return
+	}
+	if removeErr := os.Remove(d.credentials); removeErr != nil && !os.IsNotExist(removeErr) {
+		d.log.Error().Msg((&ErrCredentialIO{Path: d.credentials, Err: removeErr}).Error())
+	}
+}
+
+// Err is the callback failure that shut the tunnel down, if any.
+func (d *delayedCallbacks) Err() error {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDelayedCallbacksNotify(t *testing.T) {
+	tests := []struct {
+		name         string
+		shutdown     bool
+		wantNotified bool
+		// The once file still has to notify of the tunnel on the next start
+		wantPending bool
+	}{
+		{name: "notified after the delay", wantNotified: true},
+		{name: "shutdown during the delay keeps the credentials", shutdown: true, wantPending: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			notified := false
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				notified = true
+			}))
+			defer receiver.Close()
+			credentials := filepath.Join(t.TempDir(), "credentials.json")
+			if err := ioutil.WriteFile(credentials, []byte("{}"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			c := runContext(t, "--callback", receiver.URL, "--callback-delay", "10ms",
+				"--credentials", credentials, "--callback-once-file", filepath.Join(t.TempDir(), "callback-once"))
+			callbacks, err := NewCallbackGroup(c, testLog(), nopMetrics{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			graceShutdownC := make(chan struct{})
+			config := &QuickTunnelConfig{URL: "a.trycloudflare.com"}
+			delayed := newDelayedCallbacks(c, callbacks, config, credentialsCodec{}, nil, testLog(), graceShutdownC)
+			if test.shutdown {
+				delayed.delay = time.Hour
+				close(graceShutdownC)
+			}
+			delayed.notify()
+
+			if notified != test.wantNotified {
+				t.Errorf("notified %v, want %v", notified, test.wantNotified)
+			}
+			if err := delayed.Err(); err != nil {
+				t.Errorf("Err() = %v, want nil", err)
+			}
+			if _, err := os.Stat(credentials); err != nil {
+				t.Errorf("credentials removed: %v", err)
+			}
+			if pending := callbacks.once.Pending(config.URL); pending != test.wantPending {
+				t.Errorf("once file pending %v, want %v", pending, test.wantPending)
+			}
+		})
+	}
+}
+
+func TestDelayedCallbacksAtWarnLogLevel(t *testing.T) {
+	notified := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified <- struct{}{}
+	}))
+	defer receiver.Close()
+	c := runContext(t, "--callback", receiver.URL, "--callback-delay", "10ms", "--readonly-credentials",
+		"--loglevel", "warn", "--logfile", filepath.Join(t.TempDir(), "quick-tunnel.log"))
+	callbacks, err := NewCallbackGroup(c, testLog(), nopMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graceShutdownC := make(chan struct{})
+	defer close(graceShutdownC)
+	log := createLogger(c, true)
+	delayed := newDelayedCallbacks(c, callbacks, &QuickTunnelConfig{URL: "a.trycloudflare.com"}, credentialsCodec{}, nil, log, graceShutdownC)
+	hookedLog := log.Hook(delayed)
+	hookedLog.Info().Msg("Connection 0b8a registered")
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callbacks weren't notified at --loglevel warn")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// callbackOnceFile records the tunnel URL that the callbacks were last notified of, so a URL is notified of
+// exactly once across restarts: a stored tunnel whose URL isn't recorded, because the process stopped before
+// its callbacks succeeded, is notified of on the next start, and one that is recorded is not notified of
+// again. A rotation notifies of the new URL and records it in place of the old one. Its methods do nothing
+// on a nil callbackOnceFile, which is what is used without --callback-once-file.
+type callbackOnceFile struct {
+	path string
+}
+
+func newCallbackOnceFile(c *cli.Context) *callbackOnceFile {
+	if c.String("callback-once-file") == "" {
+		return nil
+	}
+	return &callbackOnceFile{path: c.String("callback-once-file")}
+}
+
+// Pending reports whether the callbacks still have to be notified of url. Without --callback-once-file
+// only new tunnels are notified of, so it never is.
+func (f *callbackOnceFile) Pending(url string) bool {
+	return f != nil && !f.Done(url)
+}
+
+// Done reports whether the callbacks were notified of url.
+func (f *callbackOnceFile) Done(url string) bool {
+	if f == nil {
+		return false
+	}
+	recorded, err := ioutil.ReadFile(f.path)
+	return err == nil && strings.TrimSpace(string(recorded)) == url
+}
+
+func (f *callbackOnceFile) Record(url string) error {
+	if f == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(f.path, []byte(url+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "failed to write --callback-once-file")
+	}
+	return nil
+}
+
+// Remove forgets the recorded URL along with the credentials of its tunnel.
+func (f *callbackOnceFile) Remove() error {
+	if f == nil {
+		return nil
+	}
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove --callback-once-file")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCallbackOnceFile(t *testing.T) {
+	var lock sync.Mutex
+	var notified []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		notified = append(notified, r.URL.Path)
+	}))
+	defer receiver.Close()
+	onceFile := filepath.Join(t.TempDir(), "callback-once")
+
+	// Each step is a process start, with a group of its own
+	steps := []struct {
+		name       string
+		url        string
+		remove     bool
+		wantNotify bool
+	}{
+		{name: "first URL", url: "a.trycloudflare.com", wantNotify: true},
+		{name: "restart with the same URL", url: "a.trycloudflare.com"},
+		{name: "changed URL", url: "b.trycloudflare.com", wantNotify: true},
+		{name: "restart with the changed URL", url: "b.trycloudflare.com"},
+		{name: "credentials deleted", url: "b.trycloudflare.com", remove: true, wantNotify: true},
+	}
+	for _, step := range steps {
+		group, err := NewCallbackGroup(runContext(t, "--callback", receiver.URL, "--callback-once-file", onceFile), testLog(), nopMetrics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.remove {
+			if err := group.once.Remove(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pending := group.once.Pending(step.url); pending != step.wantNotify {
+			t.Fatalf("%s: pending %v, want %v", step.name, pending, step.wantNotify)
+		}
+		lock.Lock()
+		before := len(notified)
+		lock.Unlock()
+		if err := group.Notify(&QuickTunnelConfig{URL: step.url}); err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		got := len(notified) > before
+		lock.Unlock()
+		if got != step.wantNotify {
+			t.Fatalf("%s: notified %v, want %v", step.name, got, step.wantNotify)
+		}
+	}
+}
+
+func TestCallbackOnceFileFailedCallback(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+	onceFile := filepath.Join(t.TempDir(), "callback-once")
+	group, err := NewCallbackGroup(runContext(t, "--callback", receiver.URL, "--callback-once-file", onceFile, "--callback-retry-max", "1"), testLog(), nopMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Notify(&QuickTunnelConfig{URL: "a.trycloudflare.com"}); err == nil {
+		t.Fatal("expected the callback to fail")
+	}
+	if !group.once.Pending("a.trycloudflare.com") {
+		t.Fatal("a URL whose callbacks failed must still be pending")
+	}
+}
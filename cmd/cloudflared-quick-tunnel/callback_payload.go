@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CallbackPayloadData is what a --callback-payload-template is rendered with.
+type CallbackPayloadData struct {
+	URL       string
+	TunnelID  string
+	Hostname  string
+	Timestamp string
+	// Only set with --announce-both-schemes
+	HTTPURL string
+	// The --shorten-with link, or URL when there is none
+	ShortURL string
+	// Only set with --callback-include-metadata
+	Instance *InstanceMetadata
+}
+
+// InstanceMetadata identifies the machine running the tunnel, so a receiver can tell which one owns a URL.
+type InstanceMetadata struct {
+	Hostname   string `json:"hostname"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Region     string `json:"region"`
+	Protocol   string `json:"protocol"`
+}
+
+// CallbackCredentials is what a receiver needs to run the tunnel elsewhere, sent with --callback-include-credentials.
+type CallbackCredentials struct {
+	TunnelID   string `json:"tunnel_id"`
+	AccountTag string `json:"account_tag"`
+	// Base64, as in the credentials file
+	TunnelSecret string `json:"tunnel_secret"`
+}
+
+// callbackPayload renders the body of callback notifications. By default the body is just the hostname.
+type callbackPayload struct {
+	template           *template.Template
+	contentType        string
+	instance           *InstanceMetadata
+	bothSchemes        bool
+	includeCredentials bool
+}
+
+// newCallbackPayload parses --callback-payload-template and renders it once with sample data, so that a
+// template referring to unknown variables fails at startup instead of on the first notification.
+func newCallbackPayload(c *cli.Context) (*callbackPayload, error) {
+	payload := &callbackPayload{contentType: "text/plain"}
+	text := c.String("callback-payload-template")
+	if text == "" {
+		if c.Bool("callback-include-metadata") {
+			return nil, errors.New("--callback-include-metadata requires a --callback-payload-template, the plain hostname has no room for it")
+		}
+		if c.Bool("callback-include-credentials") {
+			return nil, errors.New("--callback-include-credentials requires a JSON --callback-payload-template")
+		}
+		return payload, nil
+	}
+	tmpl, err := template.New("callback-payload-template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --callback-payload-template")
+	}
+	payload.template = tmpl
+	payload.contentType = c.String("callback-content-type")
+	payload.bothSchemes = c.Bool("announce-both-schemes")
+	if payload.includeCredentials = c.Bool("callback-include-credentials"); payload.includeCredentials && !isJSONContentType(payload.contentType) {
+		return nil, errors.New("--callback-include-credentials requires a JSON --callback-content-type")
+	}
+	if c.Bool("callback-include-metadata") {
+		if payload.instance, err = newInstanceMetadata(c); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := payload.render(&QuickTunnelConfig{URL: callbackTestHostname}); err != nil {
+		return nil, errors.Wrap(err, "invalid --callback-payload-template")
+	}
+	return payload, nil
+}
+
+func (p *callbackPayload) render(config *QuickTunnelConfig) (string, []byte, error) {
+	if p.template == nil {
+		return p.contentType, []byte(config.URL), nil
+	}
+	data := CallbackPayloadData{
+		URL:       quickTunnelURL(config.URL),
+		TunnelID:  config.Credentials.TunnelID.String(),
+		Hostname:  config.URL,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Instance:  p.instance,
+		ShortURL:  config.ShortURL,
+	}
+	if data.ShortURL == "" {
+		data.ShortURL = data.URL
+	}
+	if p.bothSchemes {
+		data.HTTPURL = quickTunnelHTTPURL(config.URL)
+	}
+	var body bytes.Buffer
+	err := p.template.Execute(&body, data)
+	if err != nil {
+		return "", nil, err
+	}
+	if !isJSONContentType(p.contentType) {
+		return p.contentType, body.Bytes(), nil
+	}
+	extra := make(map[string]interface{})
+	if p.instance != nil {
+		extra["instance"] = p.instance
+	}
+	if p.includeCredentials {
+		extra["credentials"] = CallbackCredentials{
+			TunnelID:     config.Credentials.TunnelID.String(),
+			AccountTag:   config.Credentials.AccountTag,
+			TunnelSecret: base64.StdEncoding.EncodeToString(config.Credentials.TunnelSecret),
+		}
+	}
+	return p.contentType, withFields(body.Bytes(), extra), nil
+}
+
+// withFields adds the extra fields, such as the instance metadata as "instance", to a JSON object payload,
+// except those the template already put there. Other payloads are left as they are.
+func withFields(body []byte, extra map[string]interface{}) []byte {
+	if len(extra) == 0 {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return body
+	}
+	for key, value := range extra {
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return body
+		}
+		fields[key] = encoded
+	}
+	augmented, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return augmented
+}
+
+func newInstanceMetadata(c *cli.Context) (*InstanceMetadata, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the hostname for --callback-include-metadata")
+	}
+	region := c.String("region")
+	if region == "" {
+		region = "global"
+	}
+	return &InstanceMetadata{
+		Hostname:   hostname,
+		InstanceID: c.String("instance-id"),
+		Region:     region,
+		Protocol:   c.String("protocol"),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Shutdown notifications are sent once with this timeout, so they can't hold up the shutdown.
+const shutdownCallbackTimeout = 5 * time.Second
+
+// shutdownCallbacks tells receivers that the tunnel's URL stops working as soon as a graceful shutdown
+// starts, while cloudflared is still draining connections. Its methods do nothing on a nil shutdownCallbacks.
+type shutdownCallbacks struct {
+	notifiers      []*CallbackNotifier
+	log            *zerolog.Logger
+	graceShutdownC chan struct{}
+	done           chan struct{}
+	bothSchemes    bool
+}
+
+// newShutdownCallbacks returns nil unless --callback-on-shutdown or --shutdown-callback is set.
+func newShutdownCallbacks(c *cli.Context, callbacks *CallbackGroup, log *zerolog.Logger, graceShutdownC chan struct{}) (*shutdownCallbacks, error) {
+	if !c.Bool("callback-on-shutdown") && c.String("shutdown-callback") == "" {
+		return nil, nil
+	}
+	if c.Bool("callback-on-shutdown") && callbacks.Empty() {
+		return nil, errors.New("--callback-on-shutdown requires --callback")
+	}
+	client := noRedirectClient(outboundTransport(c))
+	client.Timeout = shutdownCallbackTimeout
+	signer := newCallbackSigner(c)
+	s := &shutdownCallbacks{
+		log:            log,
+		graceShutdownC: graceShutdownC,
+		done:           make(chan struct{}),
+		bothSchemes:    c.Bool("announce-both-schemes"),
+	}
+	if c.Bool("callback-on-shutdown") {
+		for _, callback := range callbacks.notifiers {
+			notifier := newCallbackNotifier(c, callback.Target, client)
+			notifier.success = callback.success
+			notifier.token = callbacks.token
+			notifier.signer = signer
+			s.notifiers = append(s.notifiers, notifier)
+		}
+	}
+	if shutdownCallback := c.String("shutdown-callback"); shutdownCallback != "" {
+		target, err := callbackTarget(c.String("url"), shutdownCallback)
+		if err != nil {
+			return nil, err
+		}
+		success, err := parseStatusMatcher(c.String("callback-success-codes"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --callback-success-codes")
+		}
+		notifier := newCallbackNotifier(c, target, client)
+		notifier.success = success
+		notifier.token = callbacks.token
+		notifier.signer = signer
+		s.notifiers = append(s.notifiers, notifier)
+	}
+	return s, nil
+}
+
+// Start sends the shutting_down event for url once the graceful shutdown starts.
+func (s *shutdownCallbacks) Start(url string) {
+	if s == nil {
+		return
+	}
+	go func() {
+		defer close(s.done)
+		<-s.graceShutdownC
+		s.notify(url)
+	}()
+}
+
+func (s *shutdownCallbacks) notify(url string) {
+	event := LifecycleEvent{Event: eventShuttingDown, URL: url, Time: time.Now().UTC()}
+	if s.bothSchemes {
+		event.HTTPURL = quickTunnelHTTPURL(url)
+	}
+	payload, _ := json.Marshal(event)
+	var wg sync.WaitGroup
+	for _, notifier := range s.notifiers {
+		wg.Add(1)
+		go func(notifier *CallbackNotifier) {
+			defer wg.Done()
+			resp, _, err := notifier.post("application/json", payload)
+			if err == nil && !notifier.success.Match(resp.StatusCode) {
+				err = errors.Errorf("Callback error: %s", resp.Status)
+			}
+			if err != nil {
+				s.log.Err(err).Msgf("Failed to notify %s of the shutdown", notifier.Target)
+			}
+		}(notifier)
+	}
+	wg.Wait()
+}
+
+// Wait waits for the shutdown notifications to be sent, if the tunnel is shutting down gracefully.
+func (s *shutdownCallbacks) Wait() {
+	if s == nil {
+		return
+	}
+	select {
+	case <-s.graceShutdownC:
+		<-s.done
+	default:
+	}
+}
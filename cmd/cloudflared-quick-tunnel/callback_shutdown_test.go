@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestShutdownCallbacks(t *testing.T) {
+	var lock sync.Mutex
+	events := make(map[string]LifecycleEvent)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var event LifecycleEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("%s received %q: %v", r.URL.Path, body, err)
+		}
+		lock.Lock()
+		events[r.URL.Path] = event
+		lock.Unlock()
+	}))
+	defer receiver.Close()
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantPaths []string
+	}{
+		{name: "neither flag"},
+		{name: "--callback-on-shutdown", args: []string{"--callback", receiver.URL + "/callback", "--callback-on-shutdown"}, wantPaths: []string{"/callback"}},
+		{name: "--shutdown-callback", args: []string{"--shutdown-callback", receiver.URL + "/shutdown"}, wantPaths: []string{"/shutdown"}},
+		{name: "both", args: []string{"--callback", receiver.URL + "/callback", "--callback-on-shutdown", "--shutdown-callback", receiver.URL + "/shutdown"}, wantPaths: []string{"/callback", "/shutdown"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lock.Lock()
+			events = make(map[string]LifecycleEvent)
+			lock.Unlock()
+			c := runContext(t, test.args...)
+			callbacks, err := NewCallbackGroup(c, testLog(), nopMetrics{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			graceShutdownC := make(chan struct{})
+			shutdown, err := newShutdownCallbacks(c, callbacks, testLog(), graceShutdownC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shutdown.Start("https://example.trycloudflare.com")
+			close(graceShutdownC)
+			shutdown.Wait()
+
+			lock.Lock()
+			defer lock.Unlock()
+			if len(events) != len(test.wantPaths) {
+				t.Fatalf("got shutdown events %v, want them at %v", events, test.wantPaths)
+			}
+			for _, path := range test.wantPaths {
+				if event := events[path]; event.Event != eventShuttingDown || event.URL != "https://example.trycloudflare.com" {
+					t.Errorf("%s received %+v", path, event)
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownCallbacksWithoutGracefulShutdown(t *testing.T) {
+	c := runContext(t, "--shutdown-callback", "http://127.0.0.1:1/shutdown")
+	callbacks, err := NewCallbackGroup(c, testLog(), nopMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown, err := newShutdownCallbacks(c, callbacks, testLog(), make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown.Start("https://example.trycloudflare.com")
+	// Returns straight away when the tunnel stopped for another reason
+	shutdown.Wait()
+}
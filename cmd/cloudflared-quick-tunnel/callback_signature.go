@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Headers of a callback signed with --callback-signing-secret.
+const (
+	callbackTimestampHeader = "X-Quick-Tunnel-Timestamp"
+	callbackNonceHeader     = "X-Quick-Tunnel-Nonce"
+	callbackSignatureHeader = "X-Quick-Tunnel-Signature"
+)
+
+// How far a signed callback's timestamp may be from the receiver's clock before it should be rejected. The
+// clock is compared with the quick-service's when a tunnel is requested, to warn of a skew past it.
+const callbackSignatureTolerance = 5 * time.Minute
+
+// callbackSigner signs each callback request with an HMAC-SHA256 of its timestamp, nonce and body, so the
+// receiver can tell that it comes from this tunnel and is not a replay. Every attempt is signed afresh, so a
+// retry is never older than the tolerance. Its methods do nothing on a nil callbackSigner, which is what is
+// used without --callback-signing-secret.
+type callbackSigner struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func newCallbackSigner(c *cli.Context) *callbackSigner {
+	if c.String("callback-signing-secret") == "" {
+		return nil
+	}
+	return &callbackSigner{secret: []byte(c.String("callback-signing-secret")), now: time.Now}
+}
+
+func (s *callbackSigner) apply(req *http.Request, payload []byte) {
+	if s == nil {
+		return
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	nonce := uuid.New().String()
+	req.Header.Set(callbackTimestampHeader, timestamp)
+	req.Header.Set(callbackNonceHeader, nonce)
+	req.Header.Set(callbackSignatureHeader, callbackSignature(s.secret, timestamp, nonce, payload))
+}
+
+// callbackSignature is "sha256=" and the hex HMAC-SHA256 of "TIMESTAMP.NONCE.BODY" keyed with the secret.
+func callbackSignature(secret []byte, timestamp, nonce string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCallbackSignature checks a signed callback the way a receiver should: the signature must match and
+// the timestamp must be within tolerance of now, either way. Receivers should also reject a nonce they have
+// seen within the tolerance.
+func verifyCallbackSignature(secret []byte, header http.Header, payload []byte, now time.Time, tolerance time.Duration) error {
+	timestamp, nonce := header.Get(callbackTimestampHeader), header.Get(callbackNonceHeader)
+	if timestamp == "" || nonce == "" {
+		return errors.New("the callback is not signed")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid %s %q", callbackTimestampHeader, timestamp)
+	}
+	if !hmac.Equal([]byte(header.Get(callbackSignatureHeader)), []byte(callbackSignature(secret, timestamp, nonce, payload))) {
+		return errors.New("the callback signature doesn't match")
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); math.Abs(float64(skew)) > float64(tolerance) {
+		return errors.Errorf("the callback timestamp is %s off, more than the %s tolerance", skew, tolerance)
+	}
+	return nil
+}
+
+// warnClockSkew warns when the quick-service's Date header shows the local clock is further off than
+// receivers of signed callbacks tolerate. The header only has a precision of a second.
+func warnClockSkew(resp *http.Response, now time.Time, log *zerolog.Logger) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	if skew := now.Sub(date); math.Abs(float64(skew)) > float64(callbackSignatureTolerance) {
+		log.Warn().Msgf("The system clock is %s off from the quick-service's, receivers may reject signed callbacks and TLS certificates may seem invalid. Check that the clock is synchronized", skew.Round(time.Second))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestCallbackSignature(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	signer := &callbackSigner{secret: secret, now: func() time.Time { return now }}
+	payload := []byte("example.trycloudflare.com")
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	signer.apply(req, payload)
+	if req.Header.Get(callbackNonceHeader) == "" {
+		t.Fatal("expected a nonce")
+	}
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		payload []byte
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "valid", secret: secret, payload: payload, now: now},
+		{name: "receiver clock behind within tolerance", secret: secret, payload: payload, now: now.Add(-4 * time.Minute)},
+		{name: "receiver clock ahead within tolerance", secret: secret, payload: payload, now: now.Add(4 * time.Minute)},
+		{name: "receiver clock behind past tolerance", secret: secret, payload: payload, now: now.Add(-6 * time.Minute), wantErr: true},
+		{name: "receiver clock ahead past tolerance", secret: secret, payload: payload, now: now.Add(6 * time.Minute), wantErr: true},
+		{name: "wrong secret", secret: []byte("other"), payload: payload, now: now, wantErr: true},
+		{name: "tampered body", secret: secret, payload: []byte("evil.trycloudflare.com"), now: now, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyCallbackSignature(test.secret, req.Header, test.payload, test.now, callbackSignatureTolerance)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+	if err := verifyCallbackSignature(secret, http.Header{}, payload, now, callbackSignatureTolerance); err == nil {
+		t.Fatal("expected an unsigned callback to be rejected")
+	}
+}
+
+func TestCallbackSigningSecret(t *testing.T) {
+	var nonces []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := verifyCallbackSignature([]byte("secret"), r.Header, body, time.Now(), callbackSignatureTolerance); err != nil {
+			t.Error(err)
+		}
+		nonces = append(nonces, r.Header.Get(callbackNonceHeader))
+		if len(nonces) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	c := runContext(t, "--callback", receiver.URL, "--callback-signing-secret", "secret", "--callback-retry-max", "1")
+	group, err := NewCallbackGroup(c, testLog(), nopMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Notify(&QuickTunnelConfig{URL: "example.trycloudflare.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(nonces) != 2 || nonces[0] == nonces[1] {
+		t.Fatalf("expected the retry to be signed with a new nonce, got %q", nonces)
+	}
+}
+
+func TestWarnClockSkew(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		date     string
+		wantWarn bool
+	}{
+		{name: "in sync", date: now.Format(http.TimeFormat)},
+		{name: "slightly off", date: now.Add(time.Minute).Format(http.TimeFormat)},
+		{name: "far behind", date: now.Add(time.Hour).Format(http.TimeFormat), wantWarn: true},
+		{name: "far ahead", date: now.Add(-time.Hour).Format(http.TimeFormat), wantWarn: true},
+		{name: "no Date header"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := zerolog.New(&out)
+			resp := &http.Response{Header: http.Header{}}
+			if test.date != "" {
+				resp.Header.Set("Date", test.date)
+			}
+			warnClockSkew(resp, now, &log)
+			if got := strings.Contains(out.String(), "system clock"); got != test.wantWarn {
+				t.Fatalf("got warning %v, want %v: %s", got, test.wantWarn, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallbackTLSInsecure(t *testing.T) {
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "self-signed receiver is refused", args: nil, wantErr: true},
+		{name: "self-signed receiver with --callback-tls-insecure", args: []string{"--callback-tls-insecure"}, wantErr: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"--callback", receiver.URL + "/callback", "--callback-retry-max", "1"}, test.args...)
+			group, err := NewCallbackGroup(runContext(t, args...), testLog(), nopMetrics{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = group.Notify(&QuickTunnelConfig{URL: "example.trycloudflare.com"})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Notify() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallbackTLSInsecureOnlyAppliesToCallbacks(t *testing.T) {
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	c := runContext(t, "--callback-tls-insecure", "--summary-webhook", receiver.URL+"/events", "--callback-retry-max", "1")
+	webhook, err := NewSummaryWebhook(c, testLog(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhook.Close()
+	if _, err := webhook.notifier.notify("application/json", []byte(`{}`)); err == nil {
+		t.Fatal("summary webhook accepted a self-signed certificate with --callback-tls-insecure")
+	}
+}
+
+func TestCallbackTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		callback string
+		want     string
+		wantErr  bool
+	}{
+		{name: "absolute", origin: "http://localhost:8080", callback: "https://receiver.example.com/hook", want: "https://receiver.example.com/hook"},
+		{name: "absolute with a tcp origin", origin: "tcp://localhost:22", callback: "http://receiver.example.com/hook", want: "http://receiver.example.com/hook"},
+		{name: "relative to an http origin", origin: "http://localhost:8080", callback: "callback", want: "http://localhost:8080/callback"},
+		{name: "relative to an https origin", origin: "https://localhost:8443", callback: "api/callback", want: "https://localhost:8443/api/callback"},
+		{name: "relative to a tcp origin", origin: "tcp://localhost:22", callback: "callback", wantErr: true},
+		{name: "relative to a unix socket", origin: "unix:/tmp/origin.sock", callback: "callback", wantErr: true},
+		{name: "invalid", origin: "http://localhost:8080", callback: "http://[::1", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := callbackTarget(test.origin, test.callback)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
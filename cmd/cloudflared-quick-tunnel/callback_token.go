@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/net/http/httpguts"
+)
+
+// Longest acknowledgement token that is accepted from a callback receiver.
+const maxCallbackToken = 4096
+
+// callbackToken is the acknowledgement token a callback receiver answered with. It is stored with the
+// credentials and sent back in --callback-token-header on later callbacks and summary webhook events. Its
+// methods do nothing on a nil callbackToken, which is what is used without --callback-token-header.
+type callbackToken struct {
+	header string
+
+	lock  sync.Mutex
+	value string
+}
+
+func newCallbackToken(c *cli.Context) *callbackToken {
+	if c.String("callback-token-header") == "" {
+		return nil
+	}
+	return &callbackToken{header: http.CanonicalHeaderKey(c.String("callback-token-header"))}
+}
+
+func (t *callbackToken) Get() string {
+	if t == nil {
+		return ""
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.value
+}
+
+func (t *callbackToken) Set(value string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.value = value
+	t.lock.Unlock()
+}
+
+// Update stores the token in a callback response body. An empty body is ignored, and so is one that can't
+// be sent back as a header value.
+func (t *callbackToken) Update(body []byte, log *zerolog.Logger) bool {
+	if t == nil {
+		return false
+	}
+	value := strings.TrimSpace(string(body))
+	if value == "" {
+		return false
+	}
+	if len(value) > maxCallbackToken || !httpguts.ValidHeaderFieldValue(value) {
+		log.Warn().Msgf("Ignoring callback response as a token, it is not a valid %s header value", t.header)
+		return false
+	}
+	t.Set(value)
+	return true
+}
+
+func (t *callbackToken) apply(req *http.Request) {
+	if value := t.Get(); value != "" {
+		req.Header.Set(t.header, value)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// portRange is a --check-udp-source-port such as "7000" or "7000-7010".
+type portRange struct {
+	min, max int
+}
+
+func parsePortRange(spec string) (portRange, error) {
+	bounds := strings.SplitN(spec, "-", 2)
+	min, err := parsePort(bounds[0])
+	if err != nil {
+		return portRange{}, err
+	}
+	max := min
+	if len(bounds) == 2 {
+		if max, err = parsePort(bounds[1]); err != nil {
+			return portRange{}, err
+		}
+		if max < min {
+			return portRange{}, errors.Errorf("port range %q ends before it starts", spec)
+		}
+	}
+	return portRange{min: min, max: max}, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.Errorf("%q is not a port between 1 and 65535", s)
+	}
+	return port, nil
+}
+
+// validateCheckUDPSourcePort checks the port range of the UDP check. cloudflared's QUIC connections always use
+// an ephemeral source port, so it has no effect without a UDP check to apply it to.
+func validateCheckUDPSourcePort(c *cli.Context) error {
+	spec := c.String("check-udp-source-port")
+	if spec == "" {
+		return nil
+	}
+	if !c.Bool("check-udp") && !c.Bool("fail-fast-on-udp-block") {
+		return errors.New("--check-udp-source-port requires --check-udp or --fail-fast-on-udp-block")
+	}
+	if _, err := parsePortRange(spec); err != nil {
+		return errors.Wrap(err, "invalid --check-udp-source-port")
+	}
+	return nil
+}
+
+// listenCheckUDP binds the first free port of --check-udp-source-port, on --local-address if it is set. It
+// returns nil without an --check-udp-source-port.
+func listenCheckUDP(c *cli.Context) (*net.UDPConn, error) {
+	spec := c.String("check-udp-source-port")
+	if spec == "" {
+		return nil, nil
+	}
+	ports, err := parsePortRange(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --check-udp-source-port")
+	}
+	localIP := net.ParseIP(c.String("local-address"))
+	for port := ports.min; port <= ports.max; port++ {
+		conn, listenErr := net.ListenUDP("udp", &net.UDPAddr{IP: localIP, Port: port})
+		if listenErr == nil {
+			return conn, nil
+		}
+		err = listenErr
+	}
+	return nil, errors.Wrapf(err, "no free UDP port in --check-udp-source-port %s", spec)
+}
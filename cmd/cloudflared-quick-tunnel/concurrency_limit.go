@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// concurrencyLimiter caps the requests in flight to the origin at --max-concurrent-requests, for origins
+// that can only serve a few at once. Up to --max-concurrent-queue more wait for a free slot, for at most
+// --max-concurrent-timeout, and any others are answered with 503. This is unrelated to --origin-max-conns,
+// which limits connections that keepalive and HTTP/2 reuse for several requests.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+func newConcurrencyLimiter(max, queue int, timeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:   make(chan struct{}, max),
+		queue:   make(chan struct{}, queue),
+		timeout: timeout,
+	}
+}
+
+func (l *concurrencyLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting in the queue if there is room in it. It gives up when the timeout passes
+// or the client goes away.
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
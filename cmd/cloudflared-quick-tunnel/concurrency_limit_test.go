@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		queue      int
+		timeout    time.Duration
+		requests   int
+		hold       time.Duration
+		wantOK     int
+		wantLimits int
+	}{
+		{name: "within the cap", max: 2, timeout: time.Second, requests: 2, hold: 100 * time.Millisecond, wantOK: 2},
+		{name: "excess without a queue", max: 1, timeout: time.Second, requests: 3, hold: 200 * time.Millisecond, wantOK: 1, wantLimits: 2},
+		{name: "excess queued", max: 1, queue: 2, timeout: 5 * time.Second, requests: 3, hold: 50 * time.Millisecond, wantOK: 3},
+		{name: "queue full", max: 1, queue: 1, timeout: 5 * time.Second, requests: 3, hold: 200 * time.Millisecond, wantOK: 2, wantLimits: 1},
+		{name: "queue timeout", max: 1, queue: 2, timeout: 50 * time.Millisecond, requests: 3, hold: 300 * time.Millisecond, wantOK: 1, wantLimits: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				lock.Unlock()
+				time.Sleep(test.hold)
+				lock.Lock()
+				inFlight--
+				lock.Unlock()
+			})
+			handler := newConcurrencyLimiter(test.max, test.queue, test.timeout).Wrap(origin)
+
+			codes := make([]int, test.requests)
+			var wg sync.WaitGroup
+			for i := 0; i < test.requests; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					w := httptest.NewRecorder()
+					handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+					codes[i] = w.Code
+				}(i)
+				// Let each request take its slot or queue place before the next arrives
+				time.Sleep(10 * time.Millisecond)
+			}
+			wg.Wait()
+
+			ok, limited := 0, 0
+			for _, code := range codes {
+				switch code {
+				case http.StatusOK:
+					ok++
+				case http.StatusServiceUnavailable:
+					limited++
+				}
+			}
+			if ok != test.wantOK || limited != test.wantLimits {
+				t.Fatalf("got %d ok and %d 503, want %d and %d", ok, limited, test.wantOK, test.wantLimits)
+			}
+			if maxInFlight > test.max {
+				t.Fatalf("%d requests reached the origin at once, the cap is %d", maxInFlight, test.max)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterClientGone(t *testing.T) {
+	release := make(chan struct{})
+	handler := newConcurrencyLimiter(1, 1, time.Minute).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer close(release)
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	time.Sleep(10 * time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req.WithContext(ctx))
+		done <- w.Code
+	}()
+	cancel()
+	select {
+	case code := <-done:
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("got %d for a queued request whose client went away, want 503", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a queued request kept waiting after its client went away")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigFile is the run command's Before hook. It applies --config to the flags that can be set from
+// a config file, refusing keys that would otherwise be silently ignored.
+func loadConfigFile(flags []cli.Flag) cli.BeforeFunc {
+	applyConfig := altsrc.InitInputSourceWithContext(flags, altsrc.NewYamlSourceFromFlagFunc("config"))
+	return func(c *cli.Context) error {
+		path := c.String("config")
+		if path == "" {
+			return nil
+		}
+		if !c.Bool("allow-unknown-config-keys") {
+			if err := validateConfigKeys(path, flags); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+		}
+		if err := applyConfig(c); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		return nil
+	}
+}
+
+func validateConfigKeys(path string, flags []cli.Flag) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read config file")
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return errors.Wrapf(err, "invalid config file %s", path)
+	}
+
+	configKeys := make(map[string]bool)
+	commandLineOnly := make(map[string]bool)
+	for _, f := range flags {
+		_, fromConfig := f.(altsrc.FlagInputSourceExtension)
+		for _, name := range f.Names() {
+			if fromConfig {
+				configKeys[name] = true
+			} else {
+				commandLineOnly[name] = true
+			}
+		}
+	}
+	for key := range config {
+		if configKeys[key] {
+			continue
+		}
+		if commandLineOnly[key] {
+			return errors.Errorf("%s in config file %s can only be set on the command line or through its environment variable", key, path)
+		}
+		if suggestion := closestKey(key, configKeys); suggestion != "" {
+			return errors.Errorf("unknown key %s in config file %s, did you mean %s? Use --allow-unknown-config-keys to ignore it", key, path, suggestion)
+		}
+		return errors.Errorf("unknown key %s in config file %s. Use --allow-unknown-config-keys to ignore it", key, path)
+	}
+	return nil
+}
+
+// closestKey returns the known key with the smallest edit distance to key, if it is close enough to be a typo.
+func closestKey(key string, known map[string]bool) string {
+	best, bestDistance := "", len(key)/2+1
+	for candidate := range known {
+		if d := editDistance(key, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
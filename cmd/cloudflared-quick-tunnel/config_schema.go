@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+type flagSchema struct {
+	Name    string      `json:"name"`
+	Aliases []string    `json:"aliases,omitempty"`
+	EnvVars []string    `json:"env_vars,omitempty"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default,omitempty"`
+	Usage   string      `json:"usage"`
+	Hidden  bool        `json:"hidden,omitempty"`
+}
+
+// PrintConfigSchema prints the run options as JSON for tooling, read from the flag definitions themselves.
+func PrintConfigSchema(flags []cli.Flag) error {
+	schema := struct {
+		Flags []flagSchema `json:"flags"`
+	}{}
+	for _, flag := range flags {
+		if s, ok := describeFlag(flag); ok {
+			schema.Flags = append(schema.Flags, s)
+		}
+	}
+	return json.NewEncoder(os.Stdout).Encode(schema)
+}
+
+// describeFlag reads the fields every cli flag type has, like prefixEnvVars does, so the altsrc wrappers
+// around cli flags are described as the flag they wrap.
+func describeFlag(flag cli.Flag) (flagSchema, bool) {
+	value := reflect.ValueOf(flag)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return flagSchema{}, false
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return flagSchema{}, false
+	}
+	names := flag.Names()
+	s := flagSchema{
+		Name:    names[0],
+		Aliases: names[1:],
+		Type:    flagType(value.Type().Name()),
+	}
+	if envVars, ok := value.FieldByName("EnvVars").Interface().([]string); ok {
+		s.EnvVars = envVars
+	}
+	if usage, ok := value.FieldByName("Usage").Interface().(string); ok {
+		s.Usage = usage
+	}
+	if hidden, ok := value.FieldByName("Hidden").Interface().(bool); ok {
+		s.Hidden = hidden
+	}
+	if defaultValue := value.FieldByName("Value"); defaultValue.IsValid() && !defaultValue.IsZero() {
+		s.Default = flagDefault(defaultValue.Interface())
+	}
+	return s, true
+}
+
+// flagType turns the flag's type name, such as StringSliceFlag, into stringSlice.
+func flagType(typeName string) string {
+	name := strings.TrimSuffix(typeName, "Flag")
+	if name == "" {
+		return "unknown"
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// flagDefault is the default as it would be written on the command line, except for lists.
+func flagDefault(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *cli.StringSlice:
+		return v.Value()
+	case *cli.IntSlice:
+		return v.Value()
+	case *cli.Int64Slice:
+		return v.Value()
+	case *cli.Float64Slice:
+		return v.Value()
+	case time.Duration:
+		return v.String()
+	case cli.Generic:
+		return v.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/rs/zerolog"
+)
+
+// cloudflared logs these when a registered connection to the edge ends, with an error or on unregistering.
+var connectionDropped = regexp.MustCompile(`^(Connection terminated|Unregistered tunnel connection)$`)
+
+var connIndexField = regexp.MustCompile(`"` + connection.LogFieldConnIndex + `":(\d+)`)
+
+// logConnIndex returns the index of the edge connection cloudflared logged an event for. zerolog doesn't let a
+// hook read the fields of an event, so the index is found in the JSON the event has encoded so far. It is false
+// for an event without one, or a nil event.
+func logConnIndex(e *zerolog.Event) (int, bool) {
+	if e == nil {
+		return 0, false
+	}
+	buf := reflect.ValueOf(e).Elem().FieldByName("buf")
+	if buf.Kind() != reflect.Slice || buf.Type().Elem().Kind() != reflect.Uint8 {
+		return 0, false
+	}
+	match := connIndexField.FindSubmatch(buf.Bytes())
+	if match == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(string(match[1]))
+	return index, err == nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogConnIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       func(zerolog.Logger)
+		wantIndex int
+		wantOK    bool
+	}{
+		{name: "int field", log: func(l zerolog.Logger) { l.Info().Int("connIndex", 2).Msg("Connection terminated") }, wantIndex: 2, wantOK: true},
+		{name: "uint8 field", log: func(l zerolog.Logger) {
+			l.Info().Uint8("connIndex", 3).Str("location", "ams01").Msg("Connection 1a registered")
+		}, wantIndex: 3, wantOK: true},
+		{name: "context field", log: func(l zerolog.Logger) {
+			connLog := l.With().Int("connIndex", 1).Logger()
+			connLog.Info().Msg("Connection terminated")
+		}, wantIndex: 1, wantOK: true},
+		{name: "no field", log: func(l zerolog.Logger) { l.Info().Msg("Connection terminated") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var index int
+			var ok bool
+			log := zerolog.New(nil).Hook(zerolog.HookFunc(func(e *zerolog.Event, level zerolog.Level, msg string) {
+				index, ok = logConnIndex(e)
+			}))
+			test.log(log)
+			if index != test.wantIndex || ok != test.wantOK {
+				t.Fatalf("logConnIndex = %d, %v, want %d, %v", index, ok, test.wantIndex, test.wantOK)
+			}
+		})
+	}
+	if _, ok := logConnIndex(nil); ok {
+		t.Error("logConnIndex(nil) found an index")
+	}
+}
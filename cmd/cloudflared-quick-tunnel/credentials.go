@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Formats of the credentials file, chosen with --credentials-format. json-base64 is the JSON encoded as a
+// single base64 blob, for secret stores that mangle raw JSON.
+const (
+	credentialsFormatJSON       = "json"
+	credentialsFormatJSONBase64 = "json-base64"
+)
+
+// ReadQuickTunnelConfig loads the tunnel stored by a previous run. Each format is detected from the contents,
+// since a JSON file always starts with a brace, base64 never has one and encrypted files start with a header.
+func ReadQuickTunnelConfig(path string, codec credentialsCodec) (*QuickTunnelConfig, error) {
+	byteValue, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ErrCredentialIO{Path: path, Err: err}
+	}
+	if isEncryptedCredentials(byteValue) {
+		if byteValue, err = decryptCredentials(byteValue, codec.passphrase); err != nil {
+			return nil, &ErrCredentialIO{Path: path, Err: err}
+		}
+	} else if trimmed := bytes.TrimSpace(byteValue); len(trimmed) > 0 && trimmed[0] != '{' {
+		if byteValue, err = base64.StdEncoding.DecodeString(string(trimmed)); err != nil {
+			return nil, &ErrCredentialIO{Path: path, Err: errors.Wrap(err, "invalid credentials, neither JSON nor base64")}
+		}
+	}
+	var config QuickTunnelConfig
+	if err := json.Unmarshal(byteValue, &config); err != nil {
+		return nil, &ErrCredentialIO{Path: path, Err: errors.Wrap(err, "invalid credentials")}
+	}
+	if config.URL == "" {
+		return nil, &ErrCredentialIO{Path: path, Err: errors.New("no tunnel URL stored")}
+	}
+	return &config, nil
+}
+
+// WriteQuickTunnelConfig stores a newly created tunnel for later runs, in the --credentials-format format or
+// encrypted with --credentials-encrypt.
+func WriteQuickTunnelConfig(path string, codec credentialsCodec, config *QuickTunnelConfig) error {
+	file, _ := json.MarshalIndent(config, "", " ")
+	if codec.encrypt {
+		var err error
+		if file, err = encryptCredentials(file, codec.passphrase); err != nil {
+			return &ErrCredentialIO{Path: path, Err: errors.Wrap(err, "failed to encrypt credentials")}
+		}
+	} else if codec.format == credentialsFormatJSONBase64 {
+		file = []byte(base64.StdEncoding.EncodeToString(file) + "\n")
+	}
+	if err := ioutil.WriteFile(path, file, 0644); err != nil {
+		return &ErrCredentialIO{Path: path, Err: err}
+	}
+	return nil
+}
+
+// readStoredTunnel reads the --credentials file for the commands that only print what it holds.
+func readStoredTunnel(c *cli.Context) (*QuickTunnelConfig, error) {
+	codec, err := newCredentialsCodec(c)
+	if err != nil {
+		return nil, err
+	}
+	return ReadQuickTunnelConfig(c.String("credentials"), codec)
+}
+
+// PrintURL prints the public URL of the tunnel stored in the credentials file.
+func PrintURL(c *cli.Context) error {
+	config, err := readStoredTunnel(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	fmt.Println(quickTunnelURL(config.URL))
+	return nil
+}
+
+// PrintEnv prints the stored tunnel as environment variable assignments for the --shell to evaluate.
+func PrintEnv(c *cli.Context) error {
+	var format func(name, value string) string
+	switch shell := c.String("shell"); shell {
+	case "sh":
+		format = func(name, value string) string {
+			return fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+		}
+	case "fish":
+		format = func(name, value string) string {
+			value = strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value)
+			return fmt.Sprintf("set -gx %s '%s'", name, value)
+		}
+	case "powershell":
+		format = func(name, value string) string {
+			return fmt.Sprintf("$env:%s = '%s'", name, strings.ReplaceAll(value, "'", "''"))
+		}
+	default:
+		return cli.Exit(fmt.Sprintf("unsupported --shell %q, expected sh, fish or powershell", shell), 1)
+	}
+
+	config, err := readStoredTunnel(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	fmt.Println(format("TUNNEL_URL", quickTunnelURL(config.URL)))
+	fmt.Println(format("TUNNEL_ID", config.Credentials.TunnelID.String()))
+	if c.Bool("include-secret") {
+		fmt.Println(format("TUNNEL_ACCOUNT_TAG", config.Credentials.AccountTag))
+		fmt.Println(format("TUNNEL_SECRET", base64.StdEncoding.EncodeToString(config.Credentials.TunnelSecret)))
+	}
+	return nil
+}
+
+// WatchURL prints the stored tunnel URL, and again every time it changes, until interrupted. A missing or
+// partly written credentials file is skipped until the next poll.
+func WatchURL(c *cli.Context) error {
+	path := c.String("credentials")
+	interval := c.Duration("interval")
+	if interval <= 0 {
+		return cli.Exit("--interval must be positive", 1)
+	}
+	codec, err := newCredentialsCodec(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	lastURL := ""
+	for {
+		if config, err := ReadQuickTunnelConfig(path, codec); err == nil {
+			if url := quickTunnelURL(config.URL); url != lastURL {
+				fmt.Println(url)
+				lastURL = url
+			}
+		}
+		time.Sleep(interval)
+	}
+}
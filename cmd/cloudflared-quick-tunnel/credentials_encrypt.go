@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// First line of a credentials file written with --credentials-encrypt. The version covers the key derivation
+// and cipher, so they can change without breaking files written before.
+const encryptedCredentialsHeader = "quick-tunnel-encrypted-credentials-v1"
+
+// scrypt parameters for deriving the AES-256 key from the passphrase, and the size of the random salt.
+const (
+	credentialsScryptN = 1 << 15
+	credentialsScryptR = 8
+	credentialsScryptP = 1
+	credentialsKeySize = 32
+	credentialsSalt    = 16
+)
+
+var errWrongPassphrase = errors.New("wrong --credentials-passphrase, or the file was modified")
+
+// credentialsCodec is how the credentials file is encoded: its --credentials-format, and the passphrase it is
+// encrypted with when --credentials-encrypt is set. The passphrase is also used to read an encrypted file
+// without --credentials-encrypt, which is how the commands that only read the file get it.
+type credentialsCodec struct {
+	format     string
+	encrypt    bool
+	passphrase string
+}
+
+func newCredentialsCodec(c *cli.Context) (credentialsCodec, error) {
+	codec := credentialsCodec{format: c.String("credentials-format"), encrypt: c.Bool("credentials-encrypt")}
+	codec.passphrase = c.String("credentials-passphrase")
+	if path := c.String("credentials-passphrase-file"); path != "" {
+		if codec.passphrase != "" {
+			return codec, errors.New("--credentials-passphrase and --credentials-passphrase-file are contradictory")
+		}
+		passphrase, err := ioutil.ReadFile(path)
+		if err != nil {
+			return codec, errors.Wrap(err, "failed to read --credentials-passphrase-file")
+		}
+		codec.passphrase = strings.TrimRight(string(passphrase), "\r\n")
+	}
+	if codec.encrypt && codec.passphrase == "" {
+		return codec, errors.New("--credentials-encrypt requires --credentials-passphrase or --credentials-passphrase-file")
+	}
+	return codec, nil
+}
+
+func credentialsPassphraseFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "credentials-passphrase",
+			Usage:   "Passphrase the credentials file is encrypted with, see --credentials-encrypt",
+			EnvVars: []string{"TUNNEL_CREDENTIALS_PASSPHRASE"},
+		},
+		&cli.StringFlag{
+			Name:    "credentials-passphrase-file",
+			Usage:   "`FILE` holding the --credentials-passphrase, instead of passing it on the command line",
+			EnvVars: []string{"TUNNEL_CREDENTIALS_PASSPHRASE_FILE"},
+		},
+	}
+}
+
+func isEncryptedCredentials(file []byte) bool {
+	return bytes.HasPrefix(file, []byte(encryptedCredentialsHeader+"\n"))
+}
+
+// encryptCredentials seals the credentials JSON with AES-GCM, under a key derived from the passphrase with a
+// new salt each time. The file is the header line followed by the base64 of salt, nonce and ciphertext.
+func encryptCredentials(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, credentialsSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := credentialsCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, []byte(encryptedCredentialsHeader))...)
+	return []byte(encryptedCredentialsHeader + "\n" + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func decryptCredentials(file []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("the file is encrypted, set --credentials-passphrase or --credentials-passphrase-file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(file[len(encryptedCredentialsHeader):])))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encrypted credentials")
+	}
+	if len(sealed) < credentialsSalt {
+		return nil, errors.New("invalid encrypted credentials, too short")
+	}
+	gcm, err := credentialsCipher(passphrase, sealed[:credentialsSalt])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[credentialsSalt:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted credentials, too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(encryptedCredentialsHeader))
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func credentialsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, credentialsScryptN, credentialsScryptR, credentialsScryptP, credentialsKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+func TestQuickTunnelConfigRoundTrip(t *testing.T) {
+	config := &QuickTunnelConfig{
+		URL: "a.trycloudflare.com",
+		Credentials: connection.Credentials{
+			AccountTag:   "account",
+			TunnelSecret: []byte("secret"),
+			TunnelID:     uuid.New(),
+		},
+		CallbackToken: "token",
+	}
+	tests := []struct {
+		name  string
+		codec credentialsCodec
+	}{
+		{name: "json", codec: credentialsCodec{format: credentialsFormatJSON}},
+		{name: "json-base64", codec: credentialsCodec{format: credentialsFormatJSONBase64}},
+		{name: "encrypted", codec: credentialsCodec{format: credentialsFormatJSON, encrypt: true, passphrase: "passphrase"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := WriteQuickTunnelConfig(path, test.codec, config); err != nil {
+				t.Fatal(err)
+			}
+			file, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.codec.encrypt && bytes.Contains(file, []byte(config.URL)) {
+				t.Fatal("encrypted file holds the tunnel URL in plain text")
+			}
+			// Reading doesn't depend on the format it was written in, only the passphrase
+			read, err := ReadQuickTunnelConfig(path, credentialsCodec{passphrase: test.codec.passphrase})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, config) {
+				t.Fatalf("read %+v, want %+v", read, config)
+			}
+		})
+	}
+}
+
+func TestReadEncryptedQuickTunnelConfigFails(t *testing.T) {
+	encrypted, err := encryptCredentials([]byte(`{"URL":"a.trycloudflare.com"}`), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.TrimPrefix(string(encrypted), encryptedCredentialsHeader+"\n")
+	corrupted := []byte(body)
+	// Flip a character in the middle of the ciphertext, where it stays valid base64
+	if corrupted[len(corrupted)/2] == 'A' {
+		corrupted[len(corrupted)/2] = 'B'
+	} else {
+		corrupted[len(corrupted)/2] = 'A'
+	}
+
+	tests := []struct {
+		name       string
+		file       []byte
+		passphrase string
+		wantErr    error
+	}{
+		{name: "wrong passphrase", file: encrypted, passphrase: "wrong", wantErr: errWrongPassphrase},
+		{name: "corrupted", file: []byte(encryptedCredentialsHeader + "\n" + string(corrupted)), passphrase: "passphrase", wantErr: errWrongPassphrase},
+		{name: "truncated", file: []byte(encryptedCredentialsHeader + "\n" + body[:8] + "\n"), passphrase: "passphrase"},
+		{name: "header only", file: []byte(encryptedCredentialsHeader + "\n"), passphrase: "passphrase"},
+		{name: "not base64", file: []byte(encryptedCredentialsHeader + "\nnot base64!\n"), passphrase: "passphrase"},
+		{name: "no passphrase", file: encrypted},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := ioutil.WriteFile(path, test.file, 0600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := ReadQuickTunnelConfig(path, credentialsCodec{passphrase: test.passphrase})
+			if err == nil {
+				t.Fatalf("read %+v, want an error", config)
+			}
+			var credentialErr *ErrCredentialIO
+			if !errors.As(err, &credentialErr) {
+				t.Fatalf("error %v is not an ErrCredentialIO", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Fatalf("error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Set on a detached child to the file descriptor it reports its tunnel URL on.
+const detachedURLFDEnv = "QUICK_TUNNEL_URL_FD"
+
+func isDetachedChild() bool {
+	return os.Getenv(detachedURLFDEnv) != ""
+}
+
+// RunDetached starts the tunnel again as a background process. With --wait it blocks until the child knows
+// its tunnel URL and prints it, otherwise it prints the child's PID. The child's output is discarded, so use
+// --logfile to keep its logs.
+func RunDetached(c *cli.Context) error {
+	sysProcAttr, err := detachSysProcAttr()
+	if err != nil {
+		return err
+	}
+	urlReader, urlWriter, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to create URL pipe")
+	}
+	defer urlReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error determining executable path: %v", err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	// ExtraFiles start at file descriptor 3 in the child
+	cmd.Env = append(os.Environ(), detachedURLFDEnv+"=3")
+	cmd.ExtraFiles = []*os.File{urlWriter}
+	cmd.SysProcAttr = sysProcAttr
+	if err := cmd.Start(); err != nil {
+		urlWriter.Close()
+		return errors.Wrap(err, "failed to start detached tunnel")
+	}
+	urlWriter.Close()
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+
+	if !c.Bool("wait") {
+		fmt.Println(pid)
+		return nil
+	}
+	line, err := bufio.NewReader(urlReader).ReadString('\n')
+	if err != nil {
+		return errors.Errorf("detached tunnel (pid %d) exited before its URL was known", pid)
+	}
+	fmt.Println(strings.TrimSpace(line))
+	return nil
+}
+
+// reportDetachedURL hands the tunnel URL to the parent process waiting on it, if there is one.
+func reportDetachedURL(url string) error {
+	fd, err := strconv.Atoi(os.Getenv(detachedURLFDEnv))
+	if err != nil {
+		return nil
+	}
+	file := os.NewFile(uintptr(fd), "url-pipe")
+	if file == nil {
+		return nil
+	}
+	defer file.Close()
+	_, err = fmt.Fprintln(file, url)
+	return err
+}
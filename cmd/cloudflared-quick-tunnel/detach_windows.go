@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+func detachSysProcAttr() (*syscall.SysProcAttr, error) {
+	return nil, errors.New("--detach and --wait are not supported on Windows, run the tunnel as a service instead")
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// Number of log lines kept for the diagnostic bundle.
+const diagnosticLogLines = 200
+
+// Flags whose values are left out of the diagnostic bundle, in addition to any containing sensitiveFlagWords.
+// Receiver URLs often carry a token in their path.
+var sensitiveFlags = map[string]bool{
+	"credentials-contents": true,
+	"callback":             true,
+	"shutdown-callback":    true,
+	"summary-webhook":      true,
+}
+
+var sensitiveFlagWords = []string{"secret", "token", "passphrase", "password", "key"}
+
+// Header flags of the form "Key: Value" that keep only their header names in the diagnostic bundle, as the
+// values are often credentials for the origin.
+var headerValueFlags = map[string]bool{
+	"origin-request-header": true,
+	"add-response-header":   true,
+}
+
+// logRing is a zerolog hook that remembers the most recent log lines.
+type logRing struct {
+	lock  sync.Mutex
+	lines []string
+	next  int
+}
+
+func newLogRing() *logRing {
+	return &logRing{lines: make([]string, 0, diagnosticLogLines)}
+}
+
+func (r *logRing) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	line := fmt.Sprintf("%s %s %s", time.Now().UTC().Format(time.RFC3339), level, msg)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.lines) < cap(r.lines) {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+}
+
+func (r *logRing) Lines() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+type diagnosticBundle struct {
+	Time    time.Time           `json:"time"`
+	Error   string              `json:"error"`
+	Version string              `json:"version"`
+	System  map[string]string   `json:"system"`
+	Flags   map[string]string   `json:"flags"`
+	DNS     map[string][]string `json:"dns"`
+	Logs    []string            `json:"logs"`
+}
+
+// writeDiagnosticBundle saves what support needs to look into a failed run to the --trace-on-error file.
+func writeDiagnosticBundle(c *cli.Context, runErr error, recentLogs *logRing) error {
+	hostname, _ := os.Hostname()
+	redact := textRedactor(c)
+	logs := recentLogs.Lines()
+	for i, line := range logs {
+		logs[i] = redact(line)
+	}
+	bundle := diagnosticBundle{
+		Time:    time.Now().UTC(),
+		Error:   redact(runErr.Error()),
+		Version: Version,
+		System: map[string]string{
+			"os":       runtime.GOOS,
+			"arch":     runtime.GOARCH,
+			"go":       runtime.Version(),
+			"hostname": hostname,
+		},
+		Flags: redactedFlags(c),
+		DNS:   diagnosticDNS(c),
+		Logs:  logs,
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			bundle.System[name] = "set"
+		}
+	}
+	content, err := json.MarshalIndent(bundle, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.String("trace-on-error"), content, 0600)
+}
+
+func redactedFlags(c *cli.Context) map[string]string {
+	flags := make(map[string]string)
+	for _, f := range c.Command.Flags {
+		name := f.Names()[0]
+		if !c.IsSet(name) {
+			continue
+		}
+		if isSensitiveFlag(name) {
+			flags[name] = "REDACTED"
+			continue
+		}
+		var values []string
+		switch f.(type) {
+		case *cli.StringSliceFlag, *altsrc.StringSliceFlag:
+			values = c.StringSlice(name)
+		default:
+			values = []string{fmt.Sprint(c.Value(name))}
+		}
+		for i, value := range values {
+			if headerValueFlags[name] {
+				values[i] = redactHeaderValue(value)
+			} else {
+				values[i] = redactURL(value)
+			}
+		}
+		flags[name] = strings.Join(values, ",")
+	}
+	return flags
+}
+
+func redactHeaderValue(value string) string {
+	if i := strings.Index(value, ":"); i >= 0 {
+		return value[:i+1] + " REDACTED"
+	}
+	return "REDACTED"
+}
+
+// redactURL strips the userinfo and query string, which may hold credentials, from a URL value, or from the
+// URL of a value such as "/PREFIX=URL". Other values are returned unchanged.
+func redactURL(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		if u.User != nil {
+			u.User = url.User("REDACTED")
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = "REDACTED"
+		}
+		return u.String()
+	}
+	if i := strings.Index(value, "="); i >= 0 {
+		return value[:i+1] + redactURL(value[i+1:])
+	}
+	return value
+}
+
+var urlInText = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// textRedactor returns a function that redacts an error or log line for the bundle. Errors such as a failed
+// callback quote the URL they failed on, so the values of the sensitive flags are replaced wherever they appear,
+// and the URLs that remain lose their credentials as in redactURL.
+func textRedactor(c *cli.Context) func(string) string {
+	var secrets []string
+	for _, f := range c.Command.Flags {
+		name := f.Names()[0]
+		if !c.IsSet(name) || !isSensitiveFlag(name) {
+			continue
+		}
+		switch f.(type) {
+		case *cli.StringSliceFlag, *altsrc.StringSliceFlag:
+			secrets = append(secrets, c.StringSlice(name)...)
+		case *cli.StringFlag, *altsrc.StringFlag:
+			secrets = append(secrets, c.String(name))
+		}
+	}
+	// Longer values first, so a value containing another is replaced whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var pairs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, "REDACTED")
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+	return func(text string) string {
+		return urlInText.ReplaceAllStringFunc(replacer.Replace(text), func(rawURL string) string {
+			// Punctuation after a URL is usually the sentence's rather than part of the URL
+			trimmed := strings.TrimRight(rawURL, ".,:;)")
+			return redactURL(trimmed) + rawURL[len(trimmed):]
+		})
+	}
+}
+
+func isSensitiveFlag(name string) bool {
+	if sensitiveFlags[name] {
+		return true
+	}
+	for _, word := range sensitiveFlagWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnosticDNS resolves the quick-service and the edge the way the run would have.
+func diagnosticDNS(c *cli.Context) map[string][]string {
+	results := make(map[string][]string)
+	if u, err := url.Parse(c.String("quick-service")); err == nil && u.Hostname() != "" {
+		results[u.Hostname()] = lookupHost(u.Hostname())
+	}
+	if edgeAddrs := c.StringSlice("edge"); len(edgeAddrs) > 0 {
+		for _, addr := range edgeAddrs {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				results[host] = lookupHost(host)
+			}
+		}
+		return results
+	}
+	service := edgeSRVService(c)
+	srvName := fmt.Sprintf("_%s._tcp.argotunnel.com", service)
+	_, srvs, err := net.LookupSRV(service, "tcp", "argotunnel.com")
+	if err != nil {
+		results[srvName] = []string{"error: " + err.Error()}
+		return results
+	}
+	for _, srv := range srvs {
+		target := strings.TrimSuffix(srv.Target, ".")
+		results[srvName] = append(results[srvName], fmt.Sprintf("%s:%d", target, srv.Port))
+		results[target] = lookupHost(target)
+	}
+	return results
+}
+
+func lookupHost(host string) []string {
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return []string{"error: " + err.Error()}
+	}
+	return addrs
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// edgeDialAttempt is the outcome of the UDP check's QUIC handshake with one edge address.
+type edgeDialAttempt struct {
+	addr    string
+	elapsed time.Duration
+	err     error
+}
+
+// edgeCandidates resolves the edge hostnames to the addresses cloudflared connects to, so that every one of
+// them is checked: some edge IPs can be blocked while others are reachable. Hostnames that don't resolve
+// are kept for the handshake to report.
+func edgeCandidates(addrs []string, log *zerolog.Logger) []string {
+	seen := make(map[string]bool)
+	var candidates []string
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			candidates = append(candidates, addr)
+		}
+	}
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			add(addr)
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			log.Debug().Err(err).Str("edgeAddr", addr).Msg("UDP check: failed to resolve edge address")
+			add(addr)
+			continue
+		}
+		for _, ip := range ips {
+			add(net.JoinHostPort(ip.String(), port))
+		}
+	}
+	return candidates
+}
+
+// dialEdgeCandidates makes a QUIC handshake with every candidate at once, each with --edge-dial-timeout.
+func dialEdgeCandidates(c *cli.Context, candidates []string, tlsConfig *tls.Config, log *zerolog.Logger) []edgeDialAttempt {
+	attempts := make([]edgeDialAttempt, len(candidates))
+	var wg sync.WaitGroup
+	for i, addr := range candidates {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			attempts[i] = dialEdgeCandidate(c, addr, tlsConfig, log)
+		}(i, addr)
+	}
+	wg.Wait()
+	return attempts
+}
+
+func dialEdgeCandidate(c *cli.Context, addr string, tlsConfig *tls.Config, log *zerolog.Logger) edgeDialAttempt {
+	start := time.Now()
+	err := quicHandshake(c, addr, tlsConfig.Clone(), log)
+	return edgeDialAttempt{addr: addr, elapsed: time.Since(start), err: err}
+}
+
+// logEdgeDialAttempts logs each attempt and which addresses were reachable. It returns nil if any of them
+// were, and otherwise the last error.
+func logEdgeDialAttempts(attempts []edgeDialAttempt, log *zerolog.Logger) error {
+	var reachable, unreachable []string
+	var lastErr error
+	for _, attempt := range attempts {
+		event := log.Debug().Str("edgeAddr", attempt.addr).Dur("elapsed", attempt.elapsed)
+		if attempt.err != nil {
+			event.Err(attempt.err).Msg("UDP check: QUIC handshake failed")
+			unreachable = append(unreachable, attempt.addr)
+			lastErr = attempt.err
+		} else {
+			event.Msg("UDP check: QUIC handshake succeeded")
+			reachable = append(reachable, attempt.addr)
+		}
+	}
+	if len(reachable) == 0 {
+		return lastErr
+	}
+	log.Info().Msgf("UDP check: %d of %d edge addresses reachable", len(reachable), len(attempts))
+	if len(unreachable) > 0 {
+		log.Warn().Strs("unreachable", unreachable).Msgf("UDP check: no QUIC handshake with %s, cloudflared's connections to them will fail", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
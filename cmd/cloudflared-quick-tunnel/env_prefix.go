@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+const configEnvPrefixFlag = "config-env-prefix"
+
+func configEnvPrefixCliFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    configEnvPrefixFlag,
+		Usage:   "Read options from environment variables with this prefix first, for example A_TUNNEL_URL before TUNNEL_URL, so several tunnels can share an environment",
+		EnvVars: []string{"TUNNEL_CONFIG_ENV_PREFIX"},
+	}
+}
+
+// applyConfigEnvPrefix adds the prefixed name in front of every environment variable the commands' flags
+// read. The flags read the environment while they are parsed, so the prefix is looked up in the arguments
+// beforehand.
+func applyConfigEnvPrefix(commands []*cli.Command, args []string) {
+	prefix := configEnvPrefix(args)
+	if prefix == "" {
+		return
+	}
+	seen := make(map[cli.Flag]bool)
+	for _, command := range commands {
+		for _, flag := range command.Flags {
+			// Commands share some flags
+			if seen[flag] {
+				continue
+			}
+			seen[flag] = true
+			prefixEnvVars(flag, prefix)
+		}
+	}
+}
+
+func configEnvPrefix(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, configEnvPrefixFlag+"=") {
+			return strings.TrimPrefix(name, configEnvPrefixFlag+"=")
+		}
+		if name == configEnvPrefixFlag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("TUNNEL_CONFIG_ENV_PREFIX")
+}
+
+// prefixEnvVars updates the EnvVars of any flag type, including the altsrc wrappers around cli flags.
+func prefixEnvVars(flag cli.Flag, prefix string) {
+	value := reflect.ValueOf(flag)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	envVars := value.FieldByName("EnvVars")
+	if !envVars.IsValid() || !envVars.CanSet() || envVars.Len() == 0 {
+		return
+	}
+	names := envVars.Interface().([]string)
+	prefixed := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		prefixed = append(prefixed, prefix+name)
+	}
+	envVars.Set(reflect.ValueOf(append(prefixed, names...)))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The error types below are returned by the run path so callers can branch on them with errors.As.
+
+// ErrQuickServiceRejected is returned when the quick-service refuses to create a tunnel.
+type ErrQuickServiceRejected struct {
+	StatusCode int
+	Errors     []QuickTunnelError
+	// Set when the response wasn't JSON, such as an HTML error page during an incident
+	ContentType string
+	Body        string
+	// CF-RAY and rate limit headers of the response
+	Headers string
+}
+
+func (e *ErrQuickServiceRejected) Error() string {
+	return e.message() + e.headers()
+}
+
+func (e *ErrQuickServiceRejected) message() string {
+	if e.Body != "" {
+		return fmt.Sprintf("quick-service responded with %s instead of JSON, status %d: %s", e.ContentType, e.StatusCode, e.Body)
+	}
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("quick-service rejected the tunnel request with status %d", e.StatusCode)
+	}
+	messages := make([]string, len(e.Errors))
+	for i, serviceErr := range e.Errors {
+		messages[i] = fmt.Sprintf("%s (code %d)", serviceErr.Message, serviceErr.Code)
+	}
+	return fmt.Sprintf("quick-service rejected the tunnel request with status %d: %s", e.StatusCode, strings.Join(messages, ", "))
+}
+
+func (e *ErrQuickServiceRejected) headers() string {
+	if e.Headers == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", e.Headers)
+}
+
+// ErrCredentialIO is returned when the credentials file cannot be read, written or removed.
+type ErrCredentialIO struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrCredentialIO) Error() string {
+	return fmt.Sprintf("credentials file %s: %v", e.Path, e.Err)
+}
+
+func (e *ErrCredentialIO) Unwrap() error {
+	return e.Err
+}
+
+// ErrCallbackFailed is returned when the callback receiver could not be notified of the tunnel hostname.
+type ErrCallbackFailed struct {
+	Target string
+	Err    error
+}
+
+func (e *ErrCallbackFailed) Error() string {
+	return fmt.Sprintf("failed to notify callback %s: %v", e.Target, e.Err)
+}
+
+func (e *ErrCallbackFailed) Unwrap() error {
+	return e.Err
+}
+
+// ErrEdgeUnreachable is returned when the tunnel could not be served through Cloudflare's edge.
+type ErrEdgeUnreachable struct {
+	Err error
+}
+
+func (e *ErrEdgeUnreachable) Error() string {
+	return fmt.Sprintf("failed to run tunnel through the edge: %v", e.Err)
+}
+
+func (e *ErrEdgeUnreachable) Unwrap() error {
+	return e.Err
+}
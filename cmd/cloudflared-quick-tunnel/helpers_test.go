@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// runContext parses args with the flags of the run command, the way the command line would.
+func runContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	return cmdContext(t, "run", args...)
+}
+
+// cmdContext parses args with the flags of the named command.
+func cmdContext(t *testing.T, name string, args ...string) *cli.Context {
+	t.Helper()
+	var cmd *cli.Command
+	for _, candidate := range commands(nil, &maxProcsResult{}, make(chan struct{})) {
+		if candidate.Name == name {
+			cmd = candidate
+		}
+	}
+	if cmd == nil {
+		t.Fatalf("no %s command", name)
+	}
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, f := range cmd.Flags {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	ctx := cli.NewContext(&cli.App{}, set, nil)
+	ctx.Command = cmd
+	return ctx
+}
+
+func testLog() *zerolog.Logger {
+	log := zerolog.Nop()
+	return &log
+}
+
+// logConnection logs msg through hook the way cloudflared logs its connection events, with the connIndex field.
+func logConnection(hook zerolog.Hook, level zerolog.Level, msg string, index int) {
+	log := zerolog.New(ioutil.Discard).Hook(hook)
+	log.WithLevel(level).Int(connection.LogFieldConnIndex, index).Msg(msg)
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,38 @@
+package quicktest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// CallbackReceiver records the body of every callback it receives.
+type CallbackReceiver struct {
+	*httptest.Server
+
+	lock   sync.Mutex
+	bodies []string
+}
+
+// NewCallbackReceiver starts a CallbackReceiver, which is closed when the test ends.
+func NewCallbackReceiver(t *testing.T) *CallbackReceiver {
+	t.Helper()
+	receiver := &CallbackReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		receiver.lock.Lock()
+		receiver.bodies = append(receiver.bodies, string(body))
+		receiver.lock.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// Bodies returns the bodies of the callbacks received so far.
+func (r *CallbackReceiver) Bodies() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.bodies...)
+}
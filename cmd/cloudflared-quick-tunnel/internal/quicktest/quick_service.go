@@ -0,0 +1,63 @@
+// Package quicktest provides fake servers for the tunnel to talk to in tests: a quick-service and a callback
+// receiver.
+package quicktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Tunnel is the tunnel a QuickService creates, in the shape trycloudflare.com returns it.
+type Tunnel struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Hostname   string `json:"hostname"`
+	AccountTag string `json:"account_tag"`
+	Secret     []byte `json:"secret"`
+}
+
+// QuickService is a quick-service that creates a tunnel with the given hostname on each request.
+type QuickService struct {
+	*httptest.Server
+	Tunnel Tunnel
+
+	lock     sync.Mutex
+	requests int
+}
+
+// NewQuickService starts a QuickService, which is closed when the test ends.
+func NewQuickService(t *testing.T, hostname string) *QuickService {
+	t.Helper()
+	service := &QuickService{Tunnel: Tunnel{
+		ID:         "b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d",
+		Name:       "qt-test",
+		Hostname:   hostname,
+		AccountTag: "account",
+		Secret:     []byte("0123456789abcdef0123456789abcdef"),
+	}}
+	service.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tunnel" {
+			t.Errorf("quick-service received %s %s", r.Method, r.URL.Path)
+		}
+		service.lock.Lock()
+		service.requests++
+		service.lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Success bool   `json:"success"`
+			Result  Tunnel `json:"result"`
+		}{Success: true, Result: service.Tunnel})
+	}))
+	t.Cleanup(service.Close)
+	return service
+}
+
+// Requests returns how many tunnels the service has created.
+func (s *QuickService) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	cli "github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+type k8sSecret struct {
+	APIVersion string            `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string            `json:"kind" yaml:"kind"`
+	Metadata   k8sObjectMeta     `json:"metadata" yaml:"metadata"`
+	Type       string            `json:"type" yaml:"type"`
+	Data       map[string]string `json:"data" yaml:"data"`
+}
+
+type k8sObjectMeta struct {
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+// PrintK8sSecret prints the stored tunnel as a Kubernetes Secret. Its credentials.json key holds the
+// credentials file as run reads it, so the secret can be mounted and passed to --credentials.
+func PrintK8sSecret(c *cli.Context) error {
+	if c.String("name") == "" {
+		return cli.Exit("--name is required", 1)
+	}
+	config, err := readStoredTunnel(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	credentials, _ := json.MarshalIndent(config, "", " ")
+	secret := k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sObjectMeta{Name: c.String("name"), Namespace: c.String("namespace")},
+		Type:       "Opaque",
+		Data: map[string]string{
+			"credentials.json": base64.StdEncoding.EncodeToString(credentials),
+			"url":              base64.StdEncoding.EncodeToString([]byte(quickTunnelURL(config.URL))),
+			"tunnel-id":        base64.StdEncoding.EncodeToString([]byte(config.Credentials.TunnelID.String())),
+		},
+	}
+
+	var manifest []byte
+	switch output := c.String("output"); output {
+	case "yaml":
+		manifest, err = yaml.Marshal(secret)
+	case "json":
+		manifest, err = json.MarshalIndent(secret, "", "  ")
+		manifest = append(manifest, '\n')
+	default:
+		return cli.Exit(fmt.Sprintf("unsupported --output %q, expected yaml or json", output), 1)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(manifest)
+	return err
+}
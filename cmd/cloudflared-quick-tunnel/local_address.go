@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// outboundTransport is used for the requests cloudflared-quick-tunnel makes itself: to the quick-service,
+// callbacks, the summary webhook and the URL probe. Connections are made from --local-address if it is set.
+func outboundTransport(c *cli.Context) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = outboundDialer(c).DialContext
+	transport.Proxy = outboundProxy(c)
+	return transport
+}
+
+// outboundProxy is --outbound-proxy, or the proxy from the environment when it isn't set.
+func outboundProxy(c *cli.Context) func(*http.Request) (*url.URL, error) {
+	proxyURL, err := parseOutboundProxy(c.String("outbound-proxy"))
+	if err != nil {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, err
+		}
+	}
+	if proxyURL == nil {
+		return http.ProxyFromEnvironment
+	}
+	return http.ProxyURL(proxyURL)
+}
+
+func parseOutboundProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --outbound-proxy")
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+		return proxyURL, nil
+	default:
+		return nil, errors.Errorf("invalid --outbound-proxy %q, expected an http, https or socks5 URL", proxy)
+	}
+}
+
+func outboundDialer(c *cli.Context) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if localIP := net.ParseIP(c.String("local-address")); localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+	return dialer
+}
+
+func validateLocalAddress(c *cli.Context, log *zerolog.Logger) error {
+	address := c.String("local-address")
+	if address == "" {
+		return nil
+	}
+	localIP := net.ParseIP(address)
+	if localIP == nil {
+		return errors.Errorf("invalid --local-address %q, expected an IP address", address)
+	}
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return errors.Wrap(err, "failed to list local addresses")
+	}
+	for _, interfaceAddr := range interfaceAddrs {
+		if ipNet, ok := interfaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(localIP) {
+			log.Info().Msgf("Using source address %s for quick-service, callback and probe requests", localIP)
+			log.Warn().Msg("--local-address does not apply to cloudflared's edge connections, which follow the system routing table")
+			return nil
+		}
+	}
+	return errors.Errorf("--local-address %s is not assigned to any local interface", localIP)
+}
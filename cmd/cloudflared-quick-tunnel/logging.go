@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-colorable"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/term"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/cloudflare/cloudflared/logger"
+)
+
+// createLogger builds the application logger. Without the options cloudflared's logger doesn't support, it
+// writes where logger.CreateLoggerFromContext does. Unlike cloudflared's, it never disables a level below info:
+// zerolog skips the hooks of a disabled level, and the hooks that follow cloudflared's connections need its info
+// lines at any --loglevel. The lines below --loglevel are dropped by the writer instead.
+func createLogger(c *cli.Context, disableTerminal bool) *zerolog.Logger {
+	consoleTimeFormat, timeFormatErr := applyLogTimestampFormat(c)
+
+	var writers []io.Writer
+	if !disableTerminal {
+		writers = append(writers, zerolog.ConsoleWriter{
+			Out:        colorable.NewColorable(os.Stderr),
+			NoColor:    !term.IsTerminal(int(os.Stderr.Fd())),
+			TimeFormat: consoleTimeFormat,
+		})
+	}
+	fileWriter, fileErr := logFileWriter(c)
+	if fileWriter != nil {
+		writers = append(writers, fileWriter)
+	}
+	var syslogErr error
+	if c.Bool("log-syslog") {
+		var syslogWriter io.Writer
+		if syslogWriter, syslogErr = openSyslog(c); syslogWriter != nil {
+			writers = append(writers, syslogWriter)
+		}
+	}
+
+	level, levelErr := zerolog.ParseLevel(c.String(logger.LogLevelFlag))
+	if levelErr != nil {
+		level = zerolog.InfoLevel
+	}
+	logContext := zerolog.New(levelFilterWriter{level: level, writer: resilientMultiWriter{writers}}).With().Timestamp()
+	// Callers are only worth their noise when debugging
+	logCaller := c.Bool("log-caller") && level <= zerolog.DebugLevel
+	if logCaller {
+		zerolog.CallerMarshalFunc = shortCaller
+		logContext = logContext.Caller()
+	}
+	hookLevel := level
+	if hookLevel > zerolog.InfoLevel {
+		hookLevel = zerolog.InfoLevel
+	}
+	log := logContext.Logger().Level(hookLevel)
+	if c.Bool("log-caller") && !logCaller {
+		log.Warn().Msgf("--log-caller only applies with --%s debug", logger.LogLevelFlag)
+	}
+	if levelErr != nil {
+		log.Error().Msgf("Failed to parse log level %q, using %q instead", c.String(logger.LogLevelFlag), level)
+	}
+	if timeFormatErr != nil {
+		log.Error().Msg(timeFormatErr.Error())
+	}
+	if c.String(logger.LogFileFlag) != "" && c.String(logger.LogDirectoryFlag) != "" {
+		log.Error().Msgf("Your config includes values for both %s and %s, but they are incompatible. %s takes precedence.", logger.LogFileFlag, logger.LogDirectoryFlag, logger.LogFileFlag)
+	}
+	if fileErr != nil {
+		log.Err(fileErr).Msg("Failed to open the log file, logging to the terminal only")
+	}
+	if syslogErr != nil {
+		log.Err(syslogErr).Msg("Failed to connect to syslog, logging without it")
+	}
+	return &log
+}
+
+// shortCaller logs a caller in this module by its file name, and one in a dependency, such as cloudflared, by
+// its module path without the version, instead of the path the file was built from.
+func shortCaller(file string, line int) string {
+	if i := strings.LastIndex(file, "/pkg/mod/"); i >= 0 {
+		file = file[i+len("/pkg/mod/"):]
+	}
+	// Dependencies are built from directories named after their module path and version, with -trimpath too
+	if at := strings.Index(file, "@"); at >= 0 {
+		if slash := strings.Index(file[at:], "/"); slash >= 0 {
+			return file[:at] + file[at+slash:] + ":" + strconv.Itoa(line)
+		}
+	}
+	return filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+// Log file cloudflared writes in --log-directory, and how it rotates it.
+const (
+	logDirectoryFilename   = "cloudflared.log"
+	logDirectoryMaxSize    = 1
+	logDirectoryMaxBackups = 5
+)
+
+// logFileWriter opens --logfile, rotated with the log file options, or the rotated log in --log-directory,
+// the same way cloudflared's logger does. It is nil if neither is set.
+func logFileWriter(c *cli.Context) (io.Writer, error) {
+	if logRotationEnabled(c) {
+		return &lumberjack.Logger{
+			Filename:   c.String(logger.LogFileFlag),
+			MaxSize:    c.Int("log-max-size"),
+			MaxAge:     c.Int("log-max-age"),
+			MaxBackups: c.Int("log-max-backups"),
+			Compress:   c.Bool("log-compress"),
+		}, nil
+	}
+	if logFile := c.String(logger.LogFileFlag); logFile != "" {
+		if err := os.MkdirAll(filepath.Dir(logFile), 0744); err != nil {
+			return nil, err
+		}
+		return os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if dir := c.String(logger.LogDirectoryFlag); dir != "" {
+		if err := os.MkdirAll(dir, 0744); err != nil {
+			return nil, err
+		}
+		return &lumberjack.Logger{
+			Filename:   filepath.Join(dir, logDirectoryFilename),
+			MaxSize:    logDirectoryMaxSize,
+			MaxBackups: logDirectoryMaxBackups,
+		}, nil
+	}
+	return nil, nil
+}
+
+// applyLogTimestampFormat sets how zerolog writes log timestamps from --log-timestamp-format and --log-utc,
+// and returns the layout the terminal should show them in. Unix timestamps are shown as RFC 3339 there.
+// zerolog only has global settings for this, which also covers cloudflared's own logger.
+func applyLogTimestampFormat(c *cli.Context) (string, error) {
+	if c.IsSet("log-utc") && !c.Bool("log-utc") {
+		zerolog.TimestampFunc = time.Now
+	}
+	switch format := c.String("log-timestamp-format"); format {
+	case "", "rfc3339":
+		return time.RFC3339, nil
+	case "unix":
+		zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+		return time.RFC3339, nil
+	case "unixms":
+		zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+		return time.RFC3339, nil
+	default:
+		if time.Unix(0, 0).Format(format) == format {
+			return time.RFC3339, errors.Errorf("invalid --log-timestamp-format %q, expected unix, unixms, rfc3339 or a Go time layout", format)
+		}
+		zerolog.TimeFieldFormat = format
+		return format, nil
+	}
+}
+
+func logRotationEnabled(c *cli.Context) bool {
+	if c.String(logger.LogFileFlag) == "" {
+		return false
+	}
+	return c.IsSet("log-max-size") || c.IsSet("log-max-age") || c.IsSet("log-max-backups") || c.IsSet("log-compress")
+}
+
+// levelFilterWriter writes the lines of --loglevel and above. Lines logged without a level are always written.
+type levelFilterWriter struct {
+	level  zerolog.Level
+	writer zerolog.LevelWriter
+}
+
+func (w levelFilterWriter) Write(p []byte) (n int, err error) {
+	return w.writer.Write(p)
+}
+
+func (w levelFilterWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if level < w.level {
+		return len(p), nil
+	}
+	return w.writer.WriteLevel(level, p)
+}
+
+// resilientMultiWriter keeps writing to the remaining writers when one of them fails, like cloudflared's logger.
+// Writers that care about the level, like syslog, are given it.
+type resilientMultiWriter struct {
+	writers []io.Writer
+}
+
+func (t resilientMultiWriter) Write(p []byte) (n int, err error) {
+	for _, w := range t.writers {
+		_, _ = w.Write(p)
+	}
+	return len(p), nil
+}
+
+func (t resilientMultiWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	for _, w := range t.writers {
+		if lw, ok := w.(zerolog.LevelWriter); ok {
+			_, _ = lw.WriteLevel(level, p)
+		} else {
+			_, _ = w.Write(p)
+		}
+	}
+	return len(p), nil
+}
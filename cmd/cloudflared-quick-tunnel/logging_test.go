@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestShortCaller(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "/home/me/cloudflare-quick-tunnel/cmd/cloudflared-quick-tunnel/quick_tunnel.go", want: "quick_tunnel.go:12"},
+		{file: "github.com/schmidek/cloudflare-quick-tunnel/cmd/cloudflared-quick-tunnel/quick_tunnel.go", want: "quick_tunnel.go:12"},
+		{file: "/root/go/pkg/mod/github.com/cloudflare/cloudflared@v0.0.0-20211110221038-e71b88fcaa39/origin/tunnel.go", want: "github.com/cloudflare/cloudflared/origin/tunnel.go:12"},
+		{file: "github.com/cloudflare/cloudflared@v0.0.0-20211110221038-e71b88fcaa39/origin/tunnel.go", want: "github.com/cloudflare/cloudflared/origin/tunnel.go:12"},
+	}
+	for _, test := range tests {
+		if got := shortCaller(test.file, 12); got != test.want {
+			t.Errorf("shortCaller(%q) = %q, want %q", test.file, got, test.want)
+		}
+	}
+}
+
+func TestCreateLoggerCaller(t *testing.T) {
+	defer func(marshal func(string, int) string) { zerolog.CallerMarshalFunc = marshal }(zerolog.CallerMarshalFunc)
+	tests := []struct {
+		name       string
+		args       []string
+		wantCaller bool
+	}{
+		{name: "off by default", args: []string{"--loglevel", "debug"}, wantCaller: false},
+		{name: "debug level", args: []string{"--log-caller", "--loglevel", "debug"}, wantCaller: true},
+		{name: "info level", args: []string{"--log-caller"}, wantCaller: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := createLogger(runContext(t, test.args...), true).Output(&out)
+			log.Error().Msg("hello")
+			var line map[string]interface{}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &line); err != nil {
+				t.Fatal(err)
+			}
+			caller, ok := line[zerolog.CallerFieldName].(string)
+			if ok != test.wantCaller {
+				t.Fatalf("caller %q in %s, want caller %v", caller, out.String(), test.wantCaller)
+			}
+			if ok && !strings.HasPrefix(caller, "logging_test.go:") {
+				t.Errorf("caller = %q, want logging_test.go", caller)
+			}
+		})
+	}
+}
+
+func TestCreateLoggerHooksBelowLogLevel(t *testing.T) {
+	tests := []struct {
+		level       string
+		wantWritten []string
+	}{
+		{level: "debug", wantWritten: []string{"Starting", "Connection 0b8a registered", "Connection terminated"}},
+		{level: "info", wantWritten: []string{"Connection 0b8a registered", "Connection terminated"}},
+		{level: "warn", wantWritten: []string{"Connection terminated"}},
+		{level: "error", wantWritten: []string{"Connection terminated"}},
+	}
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "quick-tunnel.log")
+			log := createLogger(runContext(t, "--loglevel", test.level, "--logfile", logFile), true)
+			var hooked []string
+			hookedLog := log.Hook(zerolog.HookFunc(func(e *zerolog.Event, level zerolog.Level, msg string) {
+				hooked = append(hooked, msg)
+			}))
+			hookedLog.Debug().Msg("Starting")
+			hookedLog.Info().Msg("Connection 0b8a registered")
+			hookedLog.Error().Msg("Connection terminated")
+
+			if len(hooked) < 2 || hooked[len(hooked)-2] != "Connection 0b8a registered" {
+				t.Errorf("hooks saw %q, want the info and error lines", hooked)
+			}
+			contents, err := ioutil.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written []string
+			for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+				var fields map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &fields); err != nil {
+					t.Fatal(err)
+				}
+				written = append(written, fields[zerolog.MessageFieldName].(string))
+			}
+			if strings.Join(written, "|") != strings.Join(test.wantWritten, "|") {
+				t.Errorf("wrote %q, want %q", written, test.wantWritten)
+			}
+		})
+	}
+}
+
+func TestWatchdogDisarmedAtErrorLogLevel(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	exited := make(chan int, 1)
+	w := &watchdog{timeout: timeout, log: testLog(), stacks: ioutil.Discard, exit: func(code int) { exited <- code }}
+	log := createLogger(runContext(t, "--loglevel", "error", "--logfile", filepath.Join(t.TempDir(), "quick-tunnel.log")), true)
+	hookedLog := log.Hook(w)
+	w.Start(make(chan struct{}))
+	defer w.Stop()
+	hookedLog.Info().Msg("Connection 0b8a registered")
+	select {
+	case code := <-exited:
+		t.Fatalf("watchdog exited with %d while connected", code)
+	case <-time.After(4 * timeout):
+	}
+}
@@ -3,13 +3,14 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/getsentry/raven-go"
 	cli "github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
-	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/tunnel"
 	"github.com/cloudflare/cloudflared/connection"
@@ -43,7 +44,7 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 	metrics.RegisterBuildInfo(BuildTime, Version)
 	raven.SetRelease(Version)
-	maxprocs.Set()
+	maxProcs := autoMaxProcs()
 
 	// Graceful shutdown channel used by the app. When closed, app must terminate gracefully.
 	// Windows service manager closes this channel when it receives stop command.
@@ -63,7 +64,15 @@ func main() {
 	app.Description = `Creates a Cloudflare quick tunnel, maintains the credentials and notifies when the url of the tunnel changes`
 	//app.Flags = flags()
 	//app.Action = action(graceShutdownC)
-	app.Commands = commands(cli.ShowVersion)
+	app.Commands = commands(cli.ShowVersion, maxProcs, graceShutdownC)
+	applyConfigEnvPrefix(app.Commands, os.Args[1:])
+	// runApp ignores the error app.Run returns, so report plain errors the way cli.Exit errors are
+	app.ExitErrHandler = func(c *cli.Context, err error) {
+		if _, ok := err.(cli.ExitCoder); err != nil && !ok {
+			err = cli.Exit(err.Error(), 1)
+		}
+		cli.HandleExitCoder(err)
+	}
 
 	tunnel.Init(Version, graceShutdownC) // we need this to support the tunnel sub command...
 	//access.Init(graceShutdownC)
@@ -71,49 +80,478 @@ func main() {
 	runApp(app, graceShutdownC)
 }
 
-func commands(version func(c *cli.Context)) []*cli.Command {
+func commands(version func(c *cli.Context), maxProcs *maxProcsResult, graceShutdownC chan struct{}) []*cli.Command {
 	flags := []cli.Flag{
+		credentialsFlag(),
 		&cli.StringFlag{
-			Name:    "credentials",
-			Usage:   "specify a version you wish to upgrade or downgrade to",
-			Hidden:  false,
-			Value:   "./credentials.json",
-			EnvVars: []string{"TUNNEL_CONFIG"},
+			Name:    "credentials-format",
+			Usage:   "How the credentials file is written: json, or json-base64 for the JSON as a single base64 blob. Either is read back",
+			Value:   credentialsFormatJSON,
+			EnvVars: []string{"TUNNEL_CREDENTIALS_FORMAT"},
+		},
+		&cli.BoolFlag{
+			Name:    "credentials-encrypt",
+			Usage:   "Encrypt the credentials file with AES-GCM under a key derived from --credentials-passphrase",
+			EnvVars: []string{"TUNNEL_CREDENTIALS_ENCRYPT"},
+		},
+		&cli.BoolFlag{
+			Name:    "fail-on-existing",
+			Usage:   "Refuse to start if the credentials file already holds a tunnel, instead of reusing it",
+			EnvVars: []string{"TUNNEL_FAIL_ON_EXISTING"},
+		},
+		&cli.BoolFlag{
+			Name:    "force-new",
+			Usage:   "Delete the tunnel stored in the credentials file and create a new one, instead of reusing it",
+			EnvVars: []string{"TUNNEL_FORCE_NEW"},
+		},
+		&cli.BoolFlag{
+			Name:    "readonly-credentials",
+			Usage:   "Never write or remove the credentials file. Without one the new tunnel is only kept in memory and a restart creates another",
+			EnvVars: []string{"TUNNEL_READONLY_CREDENTIALS"},
 		},
 		&cli.StringFlag{
-			Name:    "callback",
-			Usage:   "specify a version you wish to upgrade or downgrade to",
-			Hidden:  false,
-			EnvVars: []string{"CALLBACK"},
+			Name:    "config",
+			Usage:   "YAML `FILE` with values for the cloudflared tunnel options, keyed by flag name",
+			EnvVars: []string{"TUNNEL_CONFIG_FILE"},
+		},
+		configEnvPrefixCliFlag(),
+		&cli.BoolFlag{
+			Name:  "allow-unknown-config-keys",
+			Usage: "Ignore keys in --config that are not tunnel options instead of refusing to start",
+		},
+		&cli.StringFlag{
+			Name:    "work-dir",
+			Usage:   "`DIR` for this tunnel's credentials (credentials.json), pidfile (tunnel.pid) and logs. Relative --credentials, --pidfile, --state-file, --logfile, --trace-on-error and --access-log paths are resolved against it, so each tunnel can have its own",
+			EnvVars: []string{"TUNNEL_WORK_DIR"},
+		},
+		&cli.BoolFlag{
+			Name:    "delete-on-exit",
+			Usage:   "Remove the --work-dir files after a graceful shutdown. The next run creates a new quick tunnel. Kept when the tunnel fails, so a restart can reuse them",
+			EnvVars: []string{"TUNNEL_DELETE_ON_EXIT"},
+		},
+		urlHistoryFlag(),
+		&cli.StringFlag{
+			Name:    "pidfile",
+			Usage:   "Write the process ID to this file on startup and remove it on exit",
+			EnvVars: []string{"TUNNEL_PIDFILE"},
+		},
+		&cli.BoolFlag{
+			Name:  "force-pidfile",
+			Usage: "Start even if --pidfile names a process that is still running",
+		},
+		&cli.StringFlag{
+			Name:    "readiness-address",
+			Usage:   "Serve /readyz on this `ADDRESS`, answering 200 while the tunnel is connected and 503 while it is starting or draining during a graceful shutdown",
+			EnvVars: []string{"TUNNEL_READINESS_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:    "state-file",
+			Usage:   "Keep the tunnel's state (starting, connected, draining or stopped) and URL in this JSON `FILE`",
+			EnvVars: []string{"TUNNEL_STATE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "trace-on-error",
+			Usage:   "If the tunnel fails, write a diagnostic bundle to this `FILE`: options with secrets redacted, recent log lines, DNS results for the quick-service and edge, and system information",
+			EnvVars: []string{"TUNNEL_TRACE_ON_ERROR"},
+		},
+		&cli.BoolFlag{
+			Name:    "require-origin",
+			Usage:   "Before creating or announcing the tunnel, check once that the origin answers, and exit with an error if it doesn't",
+			EnvVars: []string{"TUNNEL_REQUIRE_ORIGIN"},
+		},
+		&cli.BoolFlag{
+			Name:    "check-udp",
+			Usage:   "Before starting a QUIC tunnel, check that a QUIC handshake with the edge succeeds and warn if UDP looks blocked",
+			EnvVars: []string{"TUNNEL_CHECK_UDP"},
+		},
+		&cli.BoolFlag{
+			Name:    "fail-fast-on-udp-block",
+			Usage:   "Like --check-udp, but refuse to start if UDP looks blocked",
+			EnvVars: []string{"TUNNEL_FAIL_FAST_ON_UDP_BLOCK"},
+		},
+		&cli.DurationFlag{
+			Name:    "edge-dial-timeout",
+			Usage:   "How long the --check-udp QUIC handshake waits for each edge address. cloudflared's own connections use --dial-edge-timeout",
+			Value:   udpCheckTimeout,
+			EnvVars: []string{"TUNNEL_EDGE_DIAL_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "request-retries",
+			Usage:   "Retry a failed request for a new quick tunnel this many times with exponential backoff. Network errors, 5xx and 429 responses are retried, other refusals are not",
+			EnvVars: []string{"TUNNEL_REQUEST_RETRIES"},
+		},
+		&cli.DurationFlag{
+			Name:    "startup-jitter",
+			Usage:   "Wait a random time up to this long before requesting a new quick tunnel, so a fleet started at once doesn't hit the quick-service together",
+			EnvVars: []string{"TUNNEL_STARTUP_JITTER"},
+		},
+		&cli.IntFlag{
+			Name:    "max-procs",
+			Usage:   "Set GOMAXPROCS instead of deriving it from the container's CPU quota, for when the detection gets it wrong",
+			EnvVars: []string{"TUNNEL_MAX_PROCS"},
+		},
+		&cli.StringFlag{
+			Name:    "local-address",
+			Usage:   "Source `IP` for requests to the quick-service, callbacks and probes on multi-homed hosts. cloudflared's edge connections are not affected",
+			EnvVars: []string{"TUNNEL_LOCAL_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:    "outbound-proxy",
+			Usage:   "Proxy `URL` for requests to the quick-service, callbacks and probes, instead of HTTPS_PROXY, HTTP_PROXY and NO_PROXY. cloudflared's edge connections are not affected",
+			EnvVars: []string{"TUNNEL_OUTBOUND_PROXY"},
+		},
+		&cli.StringFlag{
+			Name:    "check-udp-source-port",
+			Usage:   "Local UDP `PORT` or range such as 7000-7010 for the --check-udp QUIC handshake, to check a source port ACL. cloudflared's QUIC connections are not affected and always use an ephemeral port",
+			EnvVars: []string{"TUNNEL_CHECK_UDP_SOURCE_PORT"},
+		},
+		&cli.StringFlag{
+			Name:    "summary-webhook",
+			Usage:   "`URL` that receives a JSON event when the tunnel starts, gets a new URL, reconnects, shuts down or fails",
+			EnvVars: []string{"TUNNEL_SUMMARY_WEBHOOK"},
+		},
+		&cli.StringFlag{
+			Name:    "shorten-with",
+			Usage:   "URL shortener API `URL` that new tunnel URLs are POSTed to as {\"url\": ...}. The short link is logged and available to callback templates as ShortURL",
+			EnvVars: []string{"TUNNEL_SHORTEN_WITH"},
+		},
+		&cli.StringFlag{
+			Name:    "shorten-field",
+			Usage:   "Dot-separated `PATH` of the short link in the --shorten-with JSON response, such as data.short_url",
+			Value:   "short_url",
+			EnvVars: []string{"TUNNEL_SHORTEN_FIELD"},
+		},
+		&cli.BoolFlag{
+			Name:    "announce-both-schemes",
+			Usage:   "Also announce the http:// form of the tunnel URL, as HTTPURL in callback templates and http_url in the summary webhook, shutdown events and --state-file",
+			EnvVars: []string{"TUNNEL_ANNOUNCE_BOTH_SCHEMES"},
+		},
+		&cli.BoolFlag{
+			Name:    "progress-json",
+			Usage:   "Write a JSON line to stderr for each startup phase: requesting_tunnel, tunnel_created, callback_sent, credentials_written, connecting_edge, connected and ready. Independent of --log-format",
+			EnvVars: []string{"TUNNEL_PROGRESS_JSON"},
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Create or load the tunnel, notify the callbacks and store the credentials, then exit instead of connecting to the edge",
+		},
+		&cli.BoolFlag{
+			Name:  "detach",
+			Usage: "Run the tunnel in the background and print its PID. Not supported on Windows",
+		},
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "Run the tunnel in the background, wait until its URL is known, print it and exit. Not supported on Windows",
 		},
 	}
+	flags = append(flags, credentialsPassphraseFlags()...)
+	flags = append(flags, callbackFlags()...)
 	flags = append(flags, configureProxyFlags(false)...)
+	flags = append(flags, originProxyFlags()...)
+	flags = append(flags, probeFlags()...)
+	flags = append(flags, metricsFlags()...)
 	flags = append(flags, tunnelFlags(true)...)
+	flags = append(flags, logFileFlags()...)
 	cmds := []*cli.Command{
 		{
 			Name: "run",
 			Action: func(c *cli.Context) (err error) {
-				log := logger.CreateLoggerFromContext(c, false)
-				RunPersistentQuickTunnel(c, log, Version)
+				if (c.Bool("detach") || c.Bool("wait")) && !isDetachedChild() {
+					return RunDetached(c)
+				}
+				if err := applyWorkDir(c); err != nil {
+					return err
+				}
+				log := createLogger(c, false)
+				if err := maxProcs.apply(c, log); err != nil {
+					return err
+				}
+				var recentLogs *logRing
+				if c.String("trace-on-error") != "" {
+					recentLogs = newLogRing()
+					hookedLog := log.Hook(recentLogs)
+					log = &hookedLog
+				}
+				if err := RunPersistentQuickTunnel(c, log, Version, graceShutdownC); err != nil {
+					if recentLogs != nil {
+						if bundleErr := writeDiagnosticBundle(c, err, recentLogs); bundleErr != nil {
+							log.Err(bundleErr).Msg("Failed to write diagnostic bundle")
+						} else {
+							log.Info().Msgf("Wrote diagnostic bundle to %s", c.String("trace-on-error"))
+						}
+					}
+					// Already logged, exit non-zero so a supervisor restarts the tunnel
+					return cli.Exit("", 1)
+				}
+				if c.Bool("delete-on-exit") {
+					cleanWorkDir(c, log)
+				}
 				return nil
 			},
 			Usage:       "Update the agent if a new version exists",
+			Before:      loadConfigFile(flags),
 			Flags:       flags,
 			Description: ``,
 		},
+		{
+			Name: "selftest",
+			Action: func(c *cli.Context) error {
+				return Selftest(c, graceShutdownC)
+			},
+			Usage:  "Check that a temporary quick tunnel in front of a built-in origin answers on its public URL",
+			Before: loadConfigFile(flags),
+			Flags: append([]cli.Flag{
+				&cli.DurationFlag{
+					Name:    "selftest-timeout",
+					Usage:   "How long to wait for the tunnel to connect and its public URL to answer",
+					Value:   2 * time.Minute,
+					EnvVars: []string{"TUNNEL_SELFTEST_TIMEOUT"},
+				},
+			}, flags...),
+			Description: `Takes the run options, but serves its own origin and keeps the credentials, pidfile and URL history in a temporary directory.
+Prints PASS or FAIL with timings, and exits non-zero on failure.`,
+		},
+		{
+			Name:   "install-systemd",
+			Action: InstallSystemd,
+			Usage:  "Print a systemd unit that runs the tunnel with the given run options",
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "install",
+					Usage: "Write the unit to /etc/systemd/system and enable it instead of printing it",
+				},
+			}, flags...),
+			Description: `The unit restarts the tunnel whenever it exits, which is how credentials that had to be regenerated are picked up.
+Credentials and callback are passed through the TUNNEL_CONFIG and CALLBACK environment variables. CALLBACK and every option with a secret,
+such as header values or URLs with credentials, are kept in /etc/cloudflared-quick-tunnel.env, which only root can read, and only written with --install.`,
+		},
+		{
+			Name: "print-config-schema",
+			Action: func(c *cli.Context) error {
+				return PrintConfigSchema(flags)
+			},
+			Usage:       "Print the run options as JSON: name, aliases, environment variables, type, default and usage of each",
+			Description: "Generated from the option definitions, for tools that build configuration for the tunnel.",
+		},
+		{
+			Name:        "url",
+			Action:      PrintURL,
+			Usage:       "Print the public URL of the tunnel stored in the credentials file",
+			Flags:       append([]cli.Flag{credentialsFlag()}, credentialsPassphraseFlags()...),
+			Description: "Exits non-zero if no tunnel has been created yet.",
+		},
+		{
+			Name:   "watch",
+			Action: WatchURL,
+			Usage:  "Print the public URL of the stored tunnel, then a line every time it changes",
+			Flags: append([]cli.Flag{
+				credentialsFlag(),
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "How often to check the credentials file",
+					Value: 5 * time.Second,
+				},
+			}, credentialsPassphraseFlags()...),
+			Description: "Runs until interrupted. Nothing is printed while no tunnel has been created.",
+		},
+		{
+			Name:   "k8s-secret",
+			Action: PrintK8sSecret,
+			Usage:  "Print the stored tunnel as a Kubernetes Secret manifest",
+			Flags: append([]cli.Flag{
+				credentialsFlag(),
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "Name of the Secret",
+				},
+				&cli.StringFlag{
+					Name:  "namespace",
+					Usage: "Namespace of the Secret, left out if not set",
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "Manifest format: yaml or json",
+					Value: "yaml",
+				},
+			}, credentialsPassphraseFlags()...),
+			Description: "The credentials.json key can be mounted as the --credentials file of a run. The url and tunnel-id keys hold the public URL and tunnel ID.",
+		},
+		{
+			Name:        "history",
+			Action:      PrintURLHistory,
+			Usage:       "Print every URL recorded in the --url-history-file of a run",
+			Flags:       []cli.Flag{urlHistoryFlag()},
+			Description: "URLs the tunnel reused from its credentials file on a restart are marked as reused.",
+		},
+		{
+			Name:   "env",
+			Action: PrintEnv,
+			Usage:  "Print the stored tunnel URL and ID as environment variables, for example eval \"$(cloudflared-quick-tunnel env)\"",
+			Flags: append([]cli.Flag{
+				credentialsFlag(),
+				&cli.StringFlag{
+					Name:  "shell",
+					Usage: "Syntax to print the variables in: sh, fish or powershell",
+					Value: "sh",
+				},
+				&cli.BoolFlag{
+					Name:  "include-secret",
+					Usage: "Also print the account tag and tunnel secret",
+				},
+			}, credentialsPassphraseFlags()...),
+			Description: "TUNNEL_URL is also the variable run reads --url from, so don't run the tunnel from a shell it was exported in.",
+		},
+		{
+			Name:   "callback-test",
+			Action: CallbackTest,
+			Usage:  "Send a sample notification to the callback receiver and print its response",
+			Flags: append(callbackFlags(), &cli.StringFlag{
+				Name:    "url",
+				Value:   "http://localhost:8080",
+				Usage:   "Origin `URL` that a relative --callback path is resolved against.",
+				EnvVars: []string{"TUNNEL_URL"},
+			}),
+			Description: "Sends the same request a tunnel run would send when its hostname changes, without creating a tunnel.",
+		},
 		{
 			Name: "version",
 			Action: func(c *cli.Context) (err error) {
+				if c.Bool("json") {
+					return PrintVersionJSON(c.Bool("verbose"))
+				}
 				version(c)
+				if c.Bool("verbose") {
+					PrintVersionDependencies()
+				}
 				return nil
 			},
-			Usage:       versionText,
+			Usage: versionText,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the version, build time, Go version and embedded cloudflared version as JSON",
+				},
+				&cli.BoolFlag{
+					Name:  "verbose",
+					Usage: "Also print the Go version and the versions of cloudflared, quic-go and urfave/cli the binary was built with, for bug reports",
+				},
+			},
 			Description: versionText,
 		},
 	}
 	return cmds
 }
 
+func credentialsFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "credentials",
+		Usage:   "File the tunnel credentials and URL are stored in",
+		Hidden:  false,
+		Value:   "./credentials.json",
+		EnvVars: []string{"TUNNEL_CONFIG"},
+	}
+}
+
+func callbackFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "callback",
+			Usage:   "URL, or path relative to --url, that is notified with a POST when the tunnel hostname changes. Multiple callbacks may be specified and are notified concurrently",
+			Hidden:  false,
+			EnvVars: []string{"CALLBACK"},
+		},
+		&cli.IntFlag{
+			Name:    "callback-quorum",
+			Usage:   "Number of callbacks that must be notified successfully for the tunnel to start",
+			Value:   1,
+			EnvVars: []string{"CALLBACK_QUORUM"},
+		},
+		&cli.DurationFlag{
+			Name:    "callback-timeout",
+			Usage:   "How long each callback is retried before giving up on it",
+			Value:   backoff.DefaultMaxElapsedTime,
+			EnvVars: []string{"CALLBACK_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "callback-retry-max",
+			Usage:   "Maximum number of times each callback is retried, whichever of this and --callback-timeout is reached first. 0 only limits the time",
+			EnvVars: []string{"CALLBACK_RETRY_MAX"},
+		},
+		&cli.BoolFlag{
+			Name:    "callback-follow-redirects",
+			Usage:   "Send the notification again to the Location a callback redirects to. Without it a redirect fails the callback without retrying",
+			EnvVars: []string{"CALLBACK_FOLLOW_REDIRECTS"},
+		},
+		&cli.BoolFlag{
+			Name:    "callback-tls-insecure",
+			Usage:   "Accept any certificate from https --callback receivers, such as a self-signed one. The summary webhook, the shutdown callback, the quick-service and the edge are still verified",
+			EnvVars: []string{"CALLBACK_TLS_INSECURE"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-token-header",
+			Usage:   "Store a token that a callback receiver answers with in the credentials file, and send it back in this `HEADER` on later callbacks and --summary-webhook events",
+			EnvVars: []string{"CALLBACK_TOKEN_HEADER"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-signing-secret",
+			Usage:   "Sign each callback with an HMAC-SHA256 of its timestamp, nonce and body keyed with this `SECRET`, in the X-Quick-Tunnel-Timestamp, X-Quick-Tunnel-Nonce and X-Quick-Tunnel-Signature headers",
+			EnvVars: []string{"CALLBACK_SIGNING_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-once-file",
+			Usage:   "`FILE` recording the URL the callbacks were notified of, so each URL is notified of exactly once across restarts, including a stored tunnel whose callbacks never succeeded",
+			EnvVars: []string{"CALLBACK_ONCE_FILE"},
+		},
+		&cli.DurationFlag{
+			Name:    "callback-delay",
+			Usage:   "Notify callbacks of a new tunnel this long after it connects to the edge, instead of before it starts, for receivers that check the URL straight away. Callbacks after a rotation are not delayed",
+			EnvVars: []string{"CALLBACK_DELAY"},
+		},
+		&cli.BoolFlag{
+			Name:    "callback-on-shutdown",
+			Usage:   "POST a shutting_down event with the tunnel URL to every --callback when the tunnel shuts down gracefully, so receivers stop using the URL",
+			EnvVars: []string{"CALLBACK_ON_SHUTDOWN"},
+		},
+		&cli.StringFlag{
+			Name:    "shutdown-callback",
+			Usage:   "`URL`, or path relative to --url, that receives the shutting_down event when the tunnel shuts down gracefully",
+			EnvVars: []string{"CALLBACK_SHUTDOWN"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-success-codes",
+			Usage:   "Comma-separated status codes and ranges a callback receiver may answer with to count as notified, for example 200-299,302",
+			Value:   "200-299",
+			EnvVars: []string{"CALLBACK_SUCCESS_CODES"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-payload-template",
+			Usage:   "Go text/template for the callback body instead of the bare hostname, with {{.URL}}, {{.TunnelID}}, {{.Hostname}} and {{.Timestamp}}",
+			EnvVars: []string{"CALLBACK_PAYLOAD_TEMPLATE"},
+		},
+		&cli.StringFlag{
+			Name:    "callback-content-type",
+			Usage:   "Content-Type of callbacks rendered from --callback-payload-template",
+			Value:   "text/plain",
+			EnvVars: []string{"CALLBACK_CONTENT_TYPE"},
+		},
+		&cli.BoolFlag{
+			Name:    "callback-include-metadata",
+			Usage:   "Add the machine's hostname, --instance-id, region and protocol to a JSON --callback-payload-template as \"instance\", and to any template as {{.Instance}}",
+			EnvVars: []string{"CALLBACK_INCLUDE_METADATA"},
+		},
+		&cli.BoolFlag{
+			Name:    "callback-include-credentials",
+			Usage:   "Add the tunnel ID, account tag and base64 tunnel secret to a JSON --callback-payload-template as \"credentials\", so the receiver can run the tunnel elsewhere. Only sent to https callbacks, and whoever receives it controls the tunnel",
+			EnvVars: []string{"CALLBACK_INCLUDE_CREDENTIALS"},
+		},
+		&cli.StringFlag{
+			Name:    "instance-id",
+			Usage:   "`ID` of this instance for --callback-include-metadata",
+			EnvVars: []string{"TUNNEL_INSTANCE_ID"},
+		},
+	}
+}
+
 /*func action(graceShutdownC chan struct{}) cli.ActionFunc {
 	return cliutil.ConfiguredAction(func(c *cli.Context) (err error) {
 		tags := make(map[string]string)
@@ -168,13 +606,13 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
 			Name:    "edge",
-			Usage:   "Address of the Cloudflare tunnel server. Only works in Cloudflare's internal testing environment.",
+			Usage:   "Address of the Cloudflare tunnel server in `HOST:PORT` form. Replaces region-based edge discovery, for testing against a private edge. Multiple addresses may be specified",
 			EnvVars: []string{"TUNNEL_EDGE"},
-			Hidden:  true,
+			Hidden:  false,
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "region",
-			Usage:   "Cloudflare Edge region to connect to. Omit or set to empty to connect to the global region.",
+			Usage:   "Cloudflare Edge region to connect to: us. Omit or set to empty to connect to the global region.",
 			EnvVars: []string{"TUNNEL_REGION"},
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
@@ -241,16 +679,16 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:   "heartbeat-interval",
-			Usage:  "Minimum idle time before sending a heartbeat.",
+			Usage:  "Minimum idle time before sending a heartbeat. Increasing it helps on high-latency (satellite, mobile) links at the cost of slower dead-connection detection. Only used by the h2mux protocol.",
 			Value:  time.Second * 5,
-			Hidden: true,
+			Hidden: false,
 		}),
 		// Note TUN-3758 , we use Int because UInt is not supported with altsrc
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:   "heartbeat-count",
-			Usage:  "Minimum number of unacked heartbeats to send before closing the connection.",
+			Usage:  "Minimum number of unacked heartbeats to send before closing the connection. Increasing it helps on high-latency (satellite, mobile) links at the cost of slower dead-connection detection. Only used by the h2mux protocol.",
 			Value:  5,
-			Hidden: true,
+			Hidden: false,
 		}),
 		// Note TUN-3758 , we use Int because UInt is not supported with altsrc
 		altsrc.NewIntFlag(&cli.IntFlag{
@@ -282,10 +720,9 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    "use-reconnect-token",
-			Usage:   "Test reestablishing connections with the new 'reconnect token' flow.",
+			Usage:   "Resume connections with a reconnect token. Only used by classic tunnels: quick tunnels always register a fresh connection, so this has no effect",
 			Value:   true,
 			EnvVars: []string{"TUNNEL_USE_RECONNECT_TOKEN"},
-			Hidden:  true,
 		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:    "dial-edge-timeout",
@@ -296,9 +733,8 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    "stdin-control",
-			Usage:   "Control the process using commands sent through stdin",
+			Usage:   "Control the process with line commands on stdin, each answered on stdout with a line starting with ok or error: url, rotate, quit and reconnect [delay]. rotate exits with status 1 for the parent process to restart the tunnel on the new URL. reload is not supported",
 			EnvVars: []string{"STDIN_CONTROL"},
-			Hidden:  true,
 			Value:   false,
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
@@ -314,9 +750,14 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:  "https://api.trycloudflare.com",
 			Hidden: true,
 		}),
+		&cli.StringSliceFlag{
+			Name:    "response-map",
+			Usage:   "Where a self-hosted --quick-service puts a tunnel field in its JSON response, as \"FIELD=PATH\" such as hostname=tunnel.host. FIELD is id, name, hostname, account_tag or secret (base64), and unmapped fields are read from trycloudflare's result.FIELD. Can be repeated",
+			EnvVars: []string{"TUNNEL_RESPONSE_MAP"},
+		},
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:    "max-fetch-size",
-			Usage:   `The maximum number of results that cloudflared can fetch from Cloudflare API for any listing operations needed`,
+			Usage:   `Has no effect on quick tunnels, which do not list anything from the Cloudflare API`,
 			EnvVars: []string{"TUNNEL_MAX_FETCH_SIZE"},
 			Hidden:  true,
 		}),
@@ -449,6 +890,307 @@ func configureProxyFlags(shouldHide bool) []cli.Flag {
 	return flags
 }
 
+func probeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:    "probe-interval",
+			Usage:   "How often to request the public tunnel URL, from its first registered edge connection on, to check it responds with an --origin-healthy-status. The tunnel shuts down after --probe-failures consecutive failures so it can be restarted. 0 disables probing",
+			EnvVars: []string{"TUNNEL_PROBE_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "probe-failures",
+			Usage:   "Number of consecutive failed probes of the public tunnel URL before shutting down",
+			Value:   3,
+			EnvVars: []string{"TUNNEL_PROBE_FAILURES"},
+		},
+		&cli.DurationFlag{
+			Name:    "watchdog-timeout",
+			Usage:   "Exit with status 1, after writing the goroutine stacks to stderr, if the connector goes this long after startup or a connection failure without registering or retrying a connection. Set it well above the --retries backoff. 0 disables the watchdog",
+			EnvVars: []string{"TUNNEL_WATCHDOG_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Usage:   "Exit with status 124 if the process is still running this long after a graceful shutdown starts. Defaults to --grace-period plus 1m",
+			EnvVars: []string{"TUNNEL_SHUTDOWN_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:    "probe-path",
+			Usage:   "Path requested by the public tunnel URL probe",
+			Value:   "/",
+			EnvVars: []string{"TUNNEL_PROBE_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-healthy-status",
+			Usage:   "Comma-separated status codes and ranges the public tunnel URL probe treats as healthy, for example 200-399,401",
+			Value:   "200-399",
+			EnvVars: []string{"TUNNEL_ORIGIN_HEALTHY_STATUS"},
+		},
+	}
+}
+
+// metricsFlags choose where the quick tunnel's own metrics go. cloudflared's metrics stay on --metrics.
+func metricsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "metrics-sink",
+			Usage:   "Where to send the callback, URL and uptime metrics: prometheus, served on --metrics, statsd or otlp",
+			Value:   "prometheus",
+			EnvVars: []string{"TUNNEL_METRICS_SINK"},
+		},
+		&cli.StringFlag{
+			Name:    "statsd-addr",
+			Usage:   "`HOST:PORT` of the StatsD server for --metrics-sink statsd",
+			EnvVars: []string{"TUNNEL_STATSD_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:    "otel-endpoint",
+			Usage:   "Base `URL` of the OTLP/HTTP collector for --metrics-sink otlp, metrics are posted to /v1/metrics",
+			EnvVars: []string{"TUNNEL_OTEL_ENDPOINT"},
+		},
+	}
+}
+
+// originProxyFlags configure the local proxy that is placed in front of --url when any of them are used.
+func originProxyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "origin-breaker-threshold",
+			Usage:   "Number of consecutive failed origin requests within --origin-breaker-window after which requests are rejected with 503 for --origin-breaker-cooldown. Requests cancelled by the client don't count. 0 disables the circuit breaker",
+			EnvVars: []string{"TUNNEL_ORIGIN_BREAKER_THRESHOLD"},
+		},
+		&cli.DurationFlag{
+			Name:    "origin-breaker-cooldown",
+			Usage:   "How long the origin circuit breaker rejects requests before letting a request through to probe the origin",
+			Value:   time.Second * 30,
+			EnvVars: []string{"TUNNEL_ORIGIN_BREAKER_COOLDOWN"},
+		},
+		&cli.DurationFlag{
+			Name:    "origin-breaker-window",
+			Usage:   "Time within which --origin-breaker-threshold consecutive failures open the circuit breaker. Older failures are forgotten",
+			Value:   time.Minute,
+			EnvVars: []string{"TUNNEL_ORIGIN_BREAKER_WINDOW"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-min-tls-version",
+			Usage:   "Lowest TLS `VERSION` accepted from an https origin: 1.0, 1.1, 1.2 or 1.3",
+			EnvVars: []string{"TUNNEL_ORIGIN_MIN_TLS_VERSION"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-client-cert",
+			Usage:   "PEM certificate `FILE` presented to an https origin that requires client certificates (mTLS). Needs --origin-client-key",
+			EnvVars: []string{"TUNNEL_ORIGIN_CLIENT_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-client-key",
+			Usage:   "PEM private key `FILE` of --origin-client-cert",
+			EnvVars: []string{"TUNNEL_ORIGIN_CLIENT_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-http2",
+			Usage:   "Offer HTTP/2 to an https origin, which multiplexes requests over fewer connections when the origin accepts it",
+			EnvVars: []string{"TUNNEL_ORIGIN_HTTP2"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-h2c",
+			Usage:   "Speak cleartext HTTP/2 (h2c) to an http origin, which must accept HTTP/2 without negotiation",
+			EnvVars: []string{"TUNNEL_ORIGIN_H2C"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-sni-from-host",
+			Usage:   "Use the hostname of the Host header, as set by --http-host-header, as the TLS server name for an https origin instead of --origin-server-name",
+			EnvVars: []string{"TUNNEL_ORIGIN_SNI_FROM_HOST"},
+		},
+		&cli.IntFlag{
+			Name:    "origin-max-conns",
+			Usage:   "Maximum number of connections to the origin, including ones in use. Requests beyond it wait for a connection. 0 means no limit; --proxy-keepalive-connections limits the idle ones",
+			EnvVars: []string{"TUNNEL_ORIGIN_MAX_CONNS"},
+		},
+		&cli.BoolFlag{
+			Name:    "disable-origin-keepalive",
+			Usage:   "Close the connection to the origin after each request instead of reusing it, for origins that misbehave with keepalive",
+			EnvVars: []string{"TUNNEL_DISABLE_ORIGIN_KEEPALIVE"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-ip-version",
+			Usage:   "Connect to the origin over IPv4 only (4), IPv6 only (6), or either (auto). Edge connections are not affected",
+			Value:   "auto",
+			EnvVars: []string{"TUNNEL_ORIGIN_IP_VERSION"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-pool-metrics",
+			Usage:   "Record the active and idle connections to the origin, and requests waiting for one, in the quick_tunnel_origin_connections metric",
+			EnvVars: []string{"TUNNEL_ORIGIN_POOL_METRICS"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-byte-metrics",
+			Usage:   "Record the bytes from and to cloudflared and the origin in the quick_tunnel_bytes_total metric, updated every --metrics-update-freq",
+			EnvVars: []string{"TUNNEL_ORIGIN_BYTE_METRICS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "route",
+			Usage:   "Send requests under a path to another origin, as \"/PREFIX=URL\" such as \"/api=http://localhost:9000\". The longest matching prefix wins and other requests go to --url. Can be repeated",
+			EnvVars: []string{"TUNNEL_ROUTE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "origin-request-header",
+			Usage:   "Header added to every request sent to the origin, as \"Key: Value\". Can be repeated",
+			EnvVars: []string{"TUNNEL_ORIGIN_REQUEST_HEADER"},
+		},
+		&cli.BoolFlag{
+			Name:    "inject-traceparent",
+			Usage:   "Add a W3C traceparent header to origin requests that don't have one, with the CF-Ray ID in its trace ID",
+			EnvVars: []string{"TUNNEL_INJECT_TRACEPARENT"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-websocket-only",
+			Usage:   "Answer requests that aren't a WebSocket upgrade with 426 Upgrade Required instead of forwarding them, for an origin that only speaks WebSocket",
+			EnvVars: []string{"TUNNEL_ORIGIN_WEBSOCKET_ONLY"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "strip-response-header",
+			Usage:   "Header `NAME` removed from every origin response before it goes back to the edge, such as Server. Can be repeated",
+			EnvVars: []string{"TUNNEL_STRIP_RESPONSE_HEADER"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "add-response-header",
+			Usage:   "Header added to every origin response, as \"Key: Value\", after --strip-response-header. Can be repeated",
+			EnvVars: []string{"TUNNEL_ADD_RESPONSE_HEADER"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-path-prefix-strip",
+			Usage:   "Path `PREFIX` removed from requests before they are sent to the origin, so /public/x reaches the origin as /x",
+			EnvVars: []string{"TUNNEL_ORIGIN_PATH_PREFIX_STRIP"},
+		},
+		&cli.StringFlag{
+			Name:    "origin-path-prefix-add",
+			Usage:   "Path `PREFIX` put in front of requests sent to the origin, after --origin-path-prefix-strip, so /x reaches the origin as /app/x",
+			EnvVars: []string{"TUNNEL_ORIGIN_PATH_PREFIX_ADD"},
+		},
+		&cli.BoolFlag{
+			Name:    "origin-path-rewrite-location",
+			Usage:   "Map the path of Location headers in origin redirects back through the path prefixes, and make redirects to the origin host relative",
+			Value:   true,
+			EnvVars: []string{"TUNNEL_ORIGIN_PATH_REWRITE_LOCATION"},
+		},
+		&cli.DurationFlag{
+			Name:    "origin-request-timeout",
+			Usage:   "Deadline for a whole request to the origin, including its response, after which 504 is returned. Separate from --proxy-connect-timeout. 0 disables it",
+			EnvVars: []string{"TUNNEL_ORIGIN_REQUEST_TIMEOUT"},
+		},
+		&cli.Int64Flag{
+			Name:    "max-request-body",
+			Usage:   "Reject requests with a body larger than this many bytes with 413 instead of forwarding them to the origin. 0 disables the limit",
+			EnvVars: []string{"TUNNEL_MAX_REQUEST_BODY"},
+		},
+		&cli.Float64Flag{
+			Name:    "rate-limit",
+			Usage:   "Answer requests beyond this many per second with 429 instead of forwarding them to the origin. 0 disables the limit",
+			EnvVars: []string{"TUNNEL_RATE_LIMIT"},
+		},
+		&cli.IntFlag{
+			Name:    "rate-limit-burst",
+			Usage:   "Number of requests allowed at once before --rate-limit applies. Defaults to one second's worth",
+			EnvVars: []string{"TUNNEL_RATE_LIMIT_BURST"},
+		},
+		&cli.BoolFlag{
+			Name:    "rate-limit-per-ip",
+			Usage:   "Apply --rate-limit to each client IP, from the CF-Connecting-IP header, instead of to all requests together",
+			EnvVars: []string{"TUNNEL_RATE_LIMIT_PER_IP"},
+		},
+		&cli.IntFlag{
+			Name:    "max-concurrent-requests",
+			Usage:   "Forward at most this many requests to the origin at once, for an origin that can't serve more. 0 disables the limit",
+			EnvVars: []string{"TUNNEL_MAX_CONCURRENT_REQUESTS"},
+		},
+		&cli.IntFlag{
+			Name:    "max-concurrent-queue",
+			Usage:   "Number of requests beyond --max-concurrent-requests that wait for a free slot. Others are answered with 503",
+			EnvVars: []string{"TUNNEL_MAX_CONCURRENT_QUEUE"},
+		},
+		&cli.DurationFlag{
+			Name:    "max-concurrent-timeout",
+			Usage:   "How long a request waits in --max-concurrent-queue before it is answered with 503",
+			Value:   30 * time.Second,
+			EnvVars: []string{"TUNNEL_MAX_CONCURRENT_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "no-traffic-alert",
+			Usage:   "Warn, and send a no_traffic event to --summary-webhook, when no request has reached the origin for this long since startup or the last request",
+			EnvVars: []string{"TUNNEL_NO_TRAFFIC_ALERT"},
+		},
+		&cli.StringFlag{
+			Name:    "access-log",
+			Usage:   "`FILE` to append a line to for every request proxied to the origin, or - for stdout",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG"},
+		},
+		&cli.StringFlag{
+			Name:    "access-log-format",
+			Usage:   "Format of --access-log lines: combined, with latency and CF-Ray appended, or json",
+			Value:   "combined",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_FORMAT"},
+		},
+	}
+}
+
+// logFileFlags rotate --logfile and format its timestamps. When no rotation option is set the file grows
+// without limit.
+func logFileFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "log-timestamp-format",
+			Usage:   "Format of log timestamps: rfc3339 (the default), unix, unixms or a Go time `LAYOUT` such as 2006-01-02T15:04:05.000Z07:00",
+			EnvVars: []string{"TUNNEL_LOG_TIMESTAMP_FORMAT"},
+		},
+		&cli.BoolFlag{
+			Name:    "log-utc",
+			Usage:   "Log timestamps in UTC. Set to false for local time",
+			Value:   true,
+			EnvVars: []string{"TUNNEL_LOG_UTC"},
+		},
+		&cli.BoolFlag{
+			Name:    "log-caller",
+			Usage:   "With --loglevel debug, add the source file and line of each log line, to tell this tool's lines from cloudflared's",
+			EnvVars: []string{"TUNNEL_LOG_CALLER"},
+		},
+		&cli.BoolFlag{
+			Name:    "log-syslog",
+			Usage:   "Also send the log to syslog, with the severity of each line's level. Not supported on Windows",
+			EnvVars: []string{"TUNNEL_LOG_SYSLOG"},
+		},
+		&cli.StringFlag{
+			Name:    "syslog-addr",
+			Usage:   "Remote syslog `ADDRESS` for --log-syslog, as host:port (UDP) or tcp://host:port. The local syslog daemon if not set",
+			EnvVars: []string{"TUNNEL_SYSLOG_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:    "syslog-tag",
+			Usage:   "Tag of the --log-syslog lines",
+			Value:   "cloudflared-quick-tunnel",
+			EnvVars: []string{"TUNNEL_SYSLOG_TAG"},
+		},
+		&cli.IntFlag{
+			Name:    "log-max-size",
+			Usage:   "Rotate --logfile once it reaches this size in megabytes (100 if only other rotation options are set)",
+			EnvVars: []string{"TUNNEL_LOG_MAX_SIZE"},
+		},
+		&cli.IntFlag{
+			Name:    "log-max-age",
+			Usage:   "Delete rotated log files older than this many days. 0 keeps them regardless of age",
+			EnvVars: []string{"TUNNEL_LOG_MAX_AGE"},
+		},
+		&cli.IntFlag{
+			Name:    "log-max-backups",
+			Usage:   "Number of rotated log files to keep. 0 keeps all of them",
+			EnvVars: []string{"TUNNEL_LOG_MAX_BACKUPS"},
+		},
+		&cli.BoolFlag{
+			Name:    "log-compress",
+			Usage:   "Compress rotated log files with gzip",
+			EnvVars: []string{"TUNNEL_LOG_COMPRESS"},
+		},
+	}
+}
+
 func configureLoggingFlags(shouldHide bool) []cli.Flag {
 	return []cli.Flag{
 		altsrc.NewStringFlag(&cli.StringFlag{
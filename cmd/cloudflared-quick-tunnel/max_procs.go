@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// maxProcsResult is the outcome of setting GOMAXPROCS from the CPU quota at startup, kept until there is a
+// logger to report it with.
+type maxProcsResult struct {
+	message string
+	err     error
+}
+
+func autoMaxProcs() *maxProcsResult {
+	result := &maxProcsResult{}
+	_, result.err = maxprocs.Set(maxprocs.Logger(func(format string, args ...interface{}) {
+		result.message = fmt.Sprintf(format, args...)
+	}))
+	return result
+}
+
+// apply logs how GOMAXPROCS was set, or overrides it with --max-procs.
+func (r *maxProcsResult) apply(c *cli.Context, log *zerolog.Logger) error {
+	if c.IsSet("max-procs") {
+		procs := c.Int("max-procs")
+		if procs < 1 {
+			return errors.New("--max-procs must be at least 1")
+		}
+		previous := runtime.GOMAXPROCS(procs)
+		log.Info().Msgf("GOMAXPROCS set to %d by --max-procs, automatic detection chose %d", procs, previous)
+		return nil
+	}
+	if r.err != nil {
+		log.Warn().Msgf("Failed to set GOMAXPROCS from the CPU quota, using %d: %s. Use --max-procs to set it", runtime.GOMAXPROCS(0), r.err)
+		return nil
+	}
+	if r.message != "" {
+		log.Debug().Msg(r.message)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Metrics recorded by cloudflared-quick-tunnel itself, on top of cloudflared's.
+const (
+	metricCallbackNotifications = "quick_tunnel_callback_notifications_total"
+	metricURLInfo               = "quick_tunnel_url_info"
+	metricUptime                = "quick_tunnel_uptime_seconds"
+	metricOriginConnections     = "quick_tunnel_origin_connections"
+	metricBytes                 = "quick_tunnel_bytes_total"
+)
+
+// How often push based sinks send the current values.
+const metricsFlushInterval = 10 * time.Second
+
+// MetricsRecorder is how the wrapper's metrics are recorded, so call sites don't depend on the --metrics-sink.
+type MetricsRecorder interface {
+	AddCounter(name string, value float64, labels map[string]string)
+	SetGauge(name string, value float64, labels map[string]string)
+	Close()
+}
+
+// NewMetricsRecorder creates the recorder for --metrics-sink. Every sink reports the uptime by itself.
+func NewMetricsRecorder(c *cli.Context, log *zerolog.Logger) (MetricsRecorder, error) {
+	switch sink := c.String("metrics-sink"); sink {
+	case "prometheus":
+		return newPrometheusRecorder(), nil
+	case "statsd":
+		if c.String("statsd-addr") == "" {
+			return nil, errors.New("--metrics-sink statsd requires --statsd-addr")
+		}
+		return newStatsdRecorder(c.String("statsd-addr"), log)
+	case "otlp":
+		if c.String("otel-endpoint") == "" {
+			return nil, errors.New("--metrics-sink otlp requires --otel-endpoint")
+		}
+		return newOTLPRecorder(c, log), nil
+	default:
+		return nil, errors.Errorf("invalid --metrics-sink %q, expected prometheus, statsd or otlp", sink)
+	}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AddCounter(string, float64, map[string]string) {}
+func (nopMetrics) SetGauge(string, float64, map[string]string)   {}
+func (nopMetrics) Close()                                        {}
+
+// Prometheus collectors are registered with the default registry, which cloudflared serves on --metrics.
+var (
+	registerPrometheusOnce sync.Once
+	prometheusCounters     = map[string]*prometheus.CounterVec{
+		metricCallbackNotifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricCallbackNotifications,
+			Help: "Callback notifications by result",
+		}, []string{"result"}),
+		metricBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricBytes,
+			Help: "Bytes through the origin proxy by direction, from and to cloudflared (edge) and the origin",
+		}, []string{"direction"}),
+	}
+	prometheusGauges = map[string]*prometheus.GaugeVec{
+		metricURLInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricURLInfo,
+			Help: "Public URL of the tunnel, 1 for the current one",
+		}, []string{"url"}),
+		metricOriginConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricOriginConnections,
+			Help: "Connections from the origin proxy to the origin by state, and requests waiting for one",
+		}, []string{"state"}),
+	}
+)
+
+type prometheusRecorder struct{}
+
+func newPrometheusRecorder() *prometheusRecorder {
+	registerPrometheusOnce.Do(func() {
+		for _, counter := range prometheusCounters {
+			prometheus.MustRegister(counter)
+		}
+		for _, gauge := range prometheusGauges {
+			prometheus.MustRegister(gauge)
+		}
+		start := time.Now()
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: metricUptime,
+			Help: "Seconds since cloudflared-quick-tunnel started",
+		}, func() float64 {
+			return time.Since(start).Seconds()
+		}))
+	})
+	return &prometheusRecorder{}
+}
+
+func (*prometheusRecorder) AddCounter(name string, value float64, labels map[string]string) {
+	if counter, ok := prometheusCounters[name]; ok {
+		counter.With(labels).Add(value)
+	}
+}
+
+func (*prometheusRecorder) SetGauge(name string, value float64, labels map[string]string) {
+	if gauge, ok := prometheusGauges[name]; ok {
+		gauge.With(labels).Set(value)
+	}
+}
+
+func (*prometheusRecorder) Close() {}
+
+// metricKey identifies a series as its name and sorted labels.
+func metricKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + labels[k])
+	}
+	return b.String()
+}
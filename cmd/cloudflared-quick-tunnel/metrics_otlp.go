@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// otlpRecorder keeps the current value of every series and pushes them all to an OTLP/HTTP collector's
+// /v1/metrics endpoint, JSON encoded, every metricsFlushInterval and on Close.
+type otlpRecorder struct {
+	endpoint string
+	client   *http.Client
+	log      *zerolog.Logger
+	start    time.Time
+	done     chan struct{}
+
+	lock   sync.Mutex
+	series map[string]*otlpSeries
+}
+
+type otlpSeries struct {
+	name    string
+	labels  map[string]string
+	counter bool
+	value   float64
+}
+
+func newOTLPRecorder(c *cli.Context, log *zerolog.Logger) *otlpRecorder {
+	r := &otlpRecorder{
+		endpoint: strings.TrimSuffix(c.String("otel-endpoint"), "/") + "/v1/metrics",
+		client:   &http.Client{Timeout: httpTimeout, Transport: outboundTransport(c)},
+		log:      log,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+		series:   make(map[string]*otlpSeries),
+	}
+	go r.run()
+	return r
+}
+
+func (r *otlpRecorder) run() {
+	ticker := time.NewTicker(metricsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *otlpRecorder) AddCounter(name string, value float64, labels map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(name, labels, true).value += value
+}
+
+func (r *otlpRecorder) SetGauge(name string, value float64, labels map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(name, labels, false).value = value
+}
+
+func (r *otlpRecorder) get(name string, labels map[string]string, counter bool) *otlpSeries {
+	key := metricKey(name, labels)
+	s, ok := r.series[key]
+	if !ok {
+		s = &otlpSeries{name: name, labels: labels, counter: counter}
+		r.series[key] = s
+	}
+	return s
+}
+
+func (r *otlpRecorder) Close() {
+	close(r.done)
+	r.flush()
+}
+
+func (r *otlpRecorder) flush() {
+	now := time.Now()
+	r.SetGauge(metricUptime, now.Sub(r.start).Seconds(), nil)
+	payload, _ := json.Marshal(r.export(now))
+	resp, err := r.client.Post(r.endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		r.log.Debug().Err(err).Msg("Failed to push metrics to OTLP endpoint")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		r.log.Debug().Msgf("OTLP endpoint rejected metrics: %s", resp.Status)
+	}
+}
+
+// export builds an ExportMetricsServiceRequest in the OTLP JSON encoding.
+func (r *otlpRecorder) export(now time.Time) map[string]interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	startNano := strconv.FormatInt(r.start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+	metrics := make([]interface{}, 0, len(r.series))
+	for _, s := range r.series {
+		attributes := make([]interface{}, 0, len(s.labels))
+		for k, v := range s.labels {
+			attributes = append(attributes, map[string]interface{}{"key": k, "value": map[string]string{"stringValue": v}})
+		}
+		dataPoint := map[string]interface{}{
+			"attributes":        attributes,
+			"startTimeUnixNano": startNano,
+			"timeUnixNano":      nowNano,
+			"asDouble":          s.value,
+		}
+		metric := map[string]interface{}{"name": s.name}
+		if s.counter {
+			metric["sum"] = map[string]interface{}{
+				"dataPoints":             []interface{}{dataPoint},
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+			}
+		} else {
+			metric["gauge"] = map[string]interface{}{"dataPoints": []interface{}{dataPoint}}
+		}
+		metrics = append(metrics, metric)
+	}
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []interface{}{
+				map[string]interface{}{"key": "service.name", "value": map[string]string{"stringValue": "cloudflared-quick-tunnel"}},
+				map[string]interface{}{"key": "service.version", "value": map[string]string{"stringValue": Version}},
+			}},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]string{"name": "cloudflared-quick-tunnel"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
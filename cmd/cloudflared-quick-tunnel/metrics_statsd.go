@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// statsdRecorder sends metrics over UDP as they are recorded, with labels as DogStatsD style tags.
+type statsdRecorder struct {
+	conn  net.Conn
+	log   *zerolog.Logger
+	start time.Time
+	done  chan struct{}
+}
+
+func newStatsdRecorder(addr string, log *zerolog.Logger) (*statsdRecorder, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --statsd-addr")
+	}
+	r := &statsdRecorder{conn: conn, log: log, start: time.Now(), done: make(chan struct{})}
+	go r.reportUptime()
+	return r, nil
+}
+
+func (r *statsdRecorder) reportUptime() {
+	ticker := time.NewTicker(metricsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.SetGauge(metricUptime, time.Since(r.start).Seconds(), nil)
+		}
+	}
+}
+
+func (r *statsdRecorder) AddCounter(name string, value float64, labels map[string]string) {
+	r.send(fmt.Sprintf("%s:%g|c%s", name, value, statsdTags(labels)))
+}
+
+func (r *statsdRecorder) SetGauge(name string, value float64, labels map[string]string) {
+	r.send(fmt.Sprintf("%s:%g|g%s", name, value, statsdTags(labels)))
+}
+
+func (r *statsdRecorder) send(line string) {
+	if _, err := r.conn.Write([]byte(line)); err != nil {
+		r.log.Debug().Err(err).Msg("Failed to send metric to statsd")
+	}
+}
+
+func (r *statsdRecorder) Close() {
+	close(r.done)
+	r.conn.Close()
+}
+
+func statsdTags(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
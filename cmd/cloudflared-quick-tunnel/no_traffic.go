@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// noTrafficAlert warns when no request has reached the origin proxy for --no-traffic-alert, counted from
+// when the proxy starts and again from each request, so a URL that was never shared or stopped being used
+// gets noticed. It alerts once per quiet stretch, with a log warning and a no_traffic summary webhook event.
+type noTrafficAlert struct {
+	window  time.Duration
+	summary *SummaryWebhook
+	log     *zerolog.Logger
+
+	lock  sync.Mutex
+	timer *time.Timer
+}
+
+func newNoTrafficAlert(window time.Duration, summary *SummaryWebhook, log *zerolog.Logger) *noTrafficAlert {
+	return &noTrafficAlert{window: window, summary: summary, log: log}
+}
+
+func (a *noTrafficAlert) Start() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.timer = time.AfterFunc(a.window, a.alert)
+}
+
+func (a *noTrafficAlert) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.lock.Lock()
+		if a.timer != nil {
+			a.timer.Reset(a.window)
+		}
+		a.lock.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *noTrafficAlert) alert() {
+	a.log.Warn().Msgf("No requests reached the origin in the last %s", a.window)
+	a.summary.Send(eventNoTraffic, nil)
+}
+
+func (a *noTrafficAlert) Stop() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
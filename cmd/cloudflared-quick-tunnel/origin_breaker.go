@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+var errOriginBreakerOpen = errors.New("origin circuit breaker is open")
+
+// originBreaker stops sending requests to the origin after a run of consecutive failures within the
+// failure window of the first one, so occasional failures spread over a long time never open it. Once the
+// cooldown has passed a single request is let through to probe the origin; its outcome decides whether
+// the breaker closes again or starts another cooldown.
+type originBreaker struct {
+	transport http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+	window    time.Duration
+	log       *zerolog.Logger
+	now       func() time.Time
+
+	lock         sync.Mutex
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+	probing      bool
+}
+
+func newOriginBreaker(transport http.RoundTripper, threshold int, cooldown, window time.Duration, log *zerolog.Logger) *originBreaker {
+	return &originBreaker{
+		transport: transport,
+		threshold: threshold,
+		cooldown:  cooldown,
+		window:    window,
+		log:       log,
+		now:       time.Now,
+	}
+}
+
+func (b *originBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		return nil, errOriginBreakerOpen
+	}
+	resp, err := b.transport.RoundTrip(req)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, errRequestBodyTooLarge):
+		// The client going away, or sending a body over --max-request-body, says nothing about the origin
+		b.release(probe)
+	default:
+		b.record(err == nil, probe)
+	}
+	return resp, err
+}
+
+func (b *originBreaker) allow() (allowed bool, probe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// release lets another request probe the origin when the probe had no outcome.
+func (b *originBreaker) release(probe bool) {
+	if !probe {
+		return
+	}
+	b.lock.Lock()
+	b.probing = false
+	b.lock.Unlock()
+}
+
+func (b *originBreaker) record(success bool, probe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if success {
+		if b.failures >= b.threshold {
+			b.log.Info().Msg("Origin is reachable again, closing circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+	now := b.now()
+	if b.failures < b.threshold && (b.failures == 0 || now.Sub(b.firstFailure) > b.window) {
+		// The earlier failures are too old to count towards opening the breaker
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures == b.threshold || probe {
+		b.openUntil = now.Add(b.cooldown)
+		b.log.Warn().Msgf("Origin failed %d consecutive requests, rejecting requests for %s", b.failures, b.cooldown)
+	}
+}
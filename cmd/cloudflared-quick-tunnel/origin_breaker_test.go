@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestOriginBreaker(t *testing.T) {
+	errOrigin := errors.New("connection refused")
+	type request struct {
+		after time.Duration
+		err   error
+		// wantRejected is whether the breaker answers without calling the origin
+		wantRejected bool
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{name: "opens at the threshold", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {err: errOrigin},
+			{wantRejected: true},
+			{after: 20 * time.Second, wantRejected: true},
+		}},
+		{name: "success resets the count", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {}, {err: errOrigin}, {err: errOrigin}, {},
+		}},
+		{name: "closes after a successful probe", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {err: errOrigin},
+			{after: 30 * time.Second},
+			{}, {},
+		}},
+		{name: "failed probe opens it again", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {err: errOrigin},
+			{after: 30 * time.Second, err: errOrigin},
+			{wantRejected: true},
+			{after: 30 * time.Second},
+			{},
+		}},
+		{name: "canceled requests don't count", requests: []request{
+			{err: errOrigin}, {err: errOrigin},
+			{err: context.Canceled}, {err: errors.Wrap(context.Canceled, "read body")},
+			{},
+		}},
+		{name: "canceled probe lets another probe through", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {err: errOrigin},
+			{after: 30 * time.Second, err: context.Canceled},
+			{},
+			{},
+		}},
+		{name: "body too large doesn't count", requests: []request{
+			{err: errOrigin}, {err: errOrigin}, {err: errRequestBodyTooLarge}, {},
+		}},
+		{name: "failures outside the window are forgotten", requests: []request{
+			{err: errOrigin}, {err: errOrigin},
+			{after: 61 * time.Second, err: errOrigin},
+			{err: errOrigin},
+			{},
+		}},
+		{name: "failures within the window add up", requests: []request{
+			{err: errOrigin},
+			{after: 30 * time.Second, err: errOrigin},
+			{after: 29 * time.Second, err: errOrigin},
+			{wantRejected: true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Now()
+			var originErr error
+			calls := 0
+			transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				if originErr != nil {
+					return nil, originErr
+				}
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			})
+			breaker := newOriginBreaker(transport, 3, 30*time.Second, time.Minute, testLog())
+			breaker.now = func() time.Time { return now }
+			for i, r := range test.requests {
+				now = now.Add(r.after)
+				originErr = r.err
+				before := calls
+				_, err := breaker.RoundTrip(&http.Request{})
+				if rejected := calls == before; rejected != r.wantRejected {
+					t.Fatalf("request %d rejected = %v, want %v", i, rejected, r.wantRejected)
+				}
+				if r.wantRejected && err != errOriginBreakerOpen {
+					t.Fatalf("request %d error = %v, want %v", i, err, errOriginBreakerOpen)
+				}
+			}
+		})
+	}
+}
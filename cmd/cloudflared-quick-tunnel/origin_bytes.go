@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Directions of the quick_tunnel_bytes_total metric.
+var byteDirections = [...]string{"edge_in", "edge_out", "origin_in", "origin_out"}
+
+const (
+	edgeIn = iota
+	edgeOut
+	originIn
+	originOut
+)
+
+// originByteCounter counts the bytes read and written on the origin proxy's connections: those cloudflared
+// opens to the proxy carry the edge's traffic, those the proxy dials carry the origin's. cloudflared's own
+// connections to the edge aren't reachable, so edge bytes are the proxied http traffic, without QUIC or
+// HTTP/2 framing. The counts are added to the metric every --metrics-update-freq, and on Close.
+type originByteCounter struct {
+	// First, for 64-bit atomic alignment on 32-bit platforms
+	counts   [len(byteDirections)]int64
+	metrics  MetricsRecorder
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func newOriginByteCounter(transport *http.Transport, metrics MetricsRecorder, interval time.Duration) *originByteCounter {
+	if interval <= 0 {
+		interval = metricsFlushInterval
+	}
+	b := &originByteCounter{
+		metrics:  metrics,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &byteCountedConn{Conn: conn, counter: b, read: originIn, written: originOut}, nil
+	}
+	return b
+}
+
+// Listener counts the bytes on the connections cloudflared opens to the proxy.
+func (b *originByteCounter) Listener(listener net.Listener) net.Listener {
+	return &byteCountedListener{Listener: listener, counter: b}
+}
+
+func (b *originByteCounter) add(direction int, n int) {
+	if n > 0 {
+		atomic.AddInt64(&b.counts[direction], int64(n))
+	}
+}
+
+func (b *originByteCounter) Run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			b.flush()
+			return
+		case <-ticker.C:
+			b.flush()
+		}
+	}
+}
+
+func (b *originByteCounter) flush() {
+	for i, direction := range byteDirections {
+		if n := atomic.SwapInt64(&b.counts[i], 0); n > 0 {
+			b.metrics.AddCounter(metricBytes, float64(n), map[string]string{"direction": direction})
+		}
+	}
+}
+
+func (b *originByteCounter) Close() {
+	close(b.done)
+	<-b.stopped
+}
+
+type byteCountedListener struct {
+	net.Listener
+	counter *originByteCounter
+}
+
+func (l *byteCountedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &byteCountedConn{Conn: conn, counter: l.counter, read: edgeIn, written: edgeOut}, nil
+}
+
+// byteCountedConn adds what is read and written on the connection to the read and written directions.
+type byteCountedConn struct {
+	net.Conn
+	counter *originByteCounter
+	read    int
+	written int
+}
+
+func (c *byteCountedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.add(c.read, n)
+	return n, err
+}
+
+func (c *byteCountedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.add(c.written, n)
+	return n, err
+}
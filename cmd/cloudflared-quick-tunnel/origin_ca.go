@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/tlsconfig"
+)
+
+// cloudflared only reads --origin-ca-pool when it first connects to the origin, and ignores a file without
+// certificates, so the pool is checked and loaded at startup. The origin proxy reuses the loaded pool.
+var originCAPool struct {
+	sync.Mutex
+	path string
+	pool *x509.CertPool
+}
+
+// loadOriginCAPool returns the system and Cloudflare roots plus the certificates in --origin-ca-pool.
+func loadOriginCAPool(c *cli.Context, log *zerolog.Logger) (*x509.CertPool, error) {
+	path := c.String(tlsconfig.OriginCAPoolFlag)
+	originCAPool.Lock()
+	defer originCAPool.Unlock()
+	if originCAPool.pool != nil && originCAPool.path == path {
+		return originCAPool.pool, nil
+	}
+	if path != "" {
+		if err := checkOriginCAPool(path); err != nil {
+			return nil, err
+		}
+	}
+	pool, err := tlsconfig.LoadOriginCA(path, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading cert pool")
+	}
+	originCAPool.path, originCAPool.pool = path, pool
+	return pool, nil
+}
+
+// checkOriginCAPool makes sure the file holds at least one PEM certificate and that all of them parse.
+// Other PEM blocks are skipped, as cloudflared does.
+func checkOriginCAPool(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "invalid --%s", tlsconfig.OriginCAPoolFlag)
+	}
+	certificates := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrapf(err, "invalid --%s %s: certificate %d", tlsconfig.OriginCAPoolFlag, path, certificates+1)
+		}
+		certificates++
+	}
+	if certificates == 0 {
+		return errors.Errorf("invalid --%s %s: no PEM encoded certificates found", tlsconfig.OriginCAPoolFlag, path)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheckOriginCAPool(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	server.Close()
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})
+
+	tests := []struct {
+		name     string
+		contents []byte
+		wantErr  bool
+	}{
+		{name: "certificate", contents: certificate},
+		{name: "certificate after another PEM block", contents: append(append([]byte{}, privateKey...), certificate...)},
+		{name: "empty", contents: []byte{}, wantErr: true},
+		{name: "not PEM", contents: []byte("not a certificate\n"), wantErr: true},
+		{name: "PEM without certificates", contents: privateKey, wantErr: true},
+		{name: "corrupt certificate", contents: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("corrupt")}), wantErr: true},
+		{name: "corrupt certificate after a valid one", contents: append(append([]byte{}, certificate...), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("corrupt")})...), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ca.pem")
+			if err := ioutil.WriteFile(path, test.contents, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkOriginCAPool(path); (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+	if err := checkOriginCAPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("expected a missing file to be rejected")
+	}
+}
+
+// A self-signed origin is only trusted when --origin-ca-pool holds its certificate, or with --no-tls-verify.
+func TestOriginTransportSelfSignedOrigin(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	caPool := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caPool, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "with --origin-ca-pool", args: []string{"--origin-ca-pool", caPool}},
+		{name: "without --origin-ca-pool", wantErr: true},
+		{name: "with --no-tls-verify", args: []string{"--no-tls-verify"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport, err := newOriginTransport(runContext(t, append([]string{"--url", origin.URL}, test.args...)...), testLog())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get(origin.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			var unknownAuthority x509.UnknownAuthorityError
+			if test.wantErr && !errors.As(err, &unknownAuthority) {
+				t.Fatalf("got error %v, want an unknown authority error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// checkOriginReachable makes one attempt to reach the origin for --require-origin, before the tunnel is
+// created or announced. An http(s) origin has to answer a request, with any status, through the same TLS
+// settings the tunnel will use; other origins have to accept a TCP connection.
+func checkOriginReachable(c *cli.Context, log *zerolog.Logger) error {
+	if c.IsSet("hello-world") {
+		return nil
+	}
+	timeout := c.Duration(ingress.ProxyConnectTimeoutFlag)
+	if socket := c.String("unix-socket"); socket != "" {
+		conn, err := net.DialTimeout("unix", socket, timeout)
+		if err != nil {
+			return errors.Wrapf(err, "--require-origin: origin --unix-socket %s is unreachable", socket)
+		}
+		return conn.Close()
+	}
+	origin, err := url.Parse(c.String("url"))
+	if err != nil {
+		return errors.Wrap(err, "invalid --url")
+	}
+	if origin.Scheme != "http" && origin.Scheme != "https" {
+		conn, err := net.DialTimeout("tcp", origin.Host, timeout)
+		if err != nil {
+			return errors.Wrapf(err, "--require-origin: origin %s is unreachable", origin)
+		}
+		return conn.Close()
+	}
+
+	transport, err := newOriginTransport(c, log)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	var roundTripper http.RoundTripper = transport
+	if c.Bool("origin-h2c") && origin.Scheme == "http" {
+		h2cTransport := newOriginH2CTransport(transport)
+		defer h2cTransport.CloseIdleConnections()
+		roundTripper = h2cTransport
+	}
+	client := &http.Client{
+		Timeout:   httpTimeout,
+		Transport: roundTripper,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(origin.String())
+	if err != nil {
+		return errors.Wrapf(err, "--require-origin: origin %s is unreachable", origin)
+	}
+	resp.Body.Close()
+	log.Info().Msgf("Origin %s answered %s", origin, resp.Status)
+	return nil
+}
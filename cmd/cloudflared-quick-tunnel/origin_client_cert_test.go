@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOriginClientCert(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "quick-tunnel"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile, corruptFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "corrupt.pem")
+	for file, contents := range map[string][]byte{
+		certFile:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyFile:     pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		corruptFile: []byte("not a certificate"),
+	} {
+		if err := ioutil.WriteFile(file, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	origin.StartTLS()
+	defer origin.Close()
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantLoadErr  bool
+		wantResponse bool
+	}{
+		{name: "without a client certificate", wantResponse: false},
+		{name: "with a client certificate", args: []string{"--origin-client-cert", certFile, "--origin-client-key", keyFile}, wantResponse: true},
+		{name: "certificate without key", args: []string{"--origin-client-cert", certFile}, wantLoadErr: true},
+		{name: "key without certificate", args: []string{"--origin-client-key", keyFile}, wantLoadErr: true},
+		{name: "corrupt certificate", args: []string{"--origin-client-cert", corruptFile, "--origin-client-key", keyFile}, wantLoadErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"--url", origin.URL, "--no-tls-verify"}, test.args...)
+			transport, err := newOriginTransport(runContext(t, args...), testLog())
+			if test.wantLoadErr {
+				if err == nil {
+					t.Fatal("expected an error loading the client certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get(origin.URL)
+			if test.wantResponse != (err == nil) {
+				t.Fatalf("origin request error %v, want a response %v", err, test.wantResponse)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
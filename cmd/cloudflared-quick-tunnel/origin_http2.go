@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/net/http2"
+
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/tlsconfig"
+)
+
+// validateOriginHTTP2 checks --origin-http2 and --origin-h2c against the --url origin. HTTP/2 over TLS is
+// negotiated with ALPN, so it needs an https origin, while h2c is cleartext and none of the TLS options apply.
+func validateOriginHTTP2(c *cli.Context, origin *url.URL) error {
+	if c.Bool("origin-http2") && c.Bool("origin-h2c") {
+		return errors.New("--origin-http2 and --origin-h2c are contradictory, use --origin-http2 for an https --url and --origin-h2c for an http one")
+	}
+	if c.Bool("origin-http2") && origin.Scheme != "https" {
+		return errors.New("--origin-http2 requires an https --url, use --origin-h2c for cleartext HTTP/2")
+	}
+	if !c.Bool("origin-h2c") {
+		return nil
+	}
+	if origin.Scheme != "http" {
+		return errors.New("--origin-h2c requires an http --url, use --origin-http2 for an https origin")
+	}
+	for _, flag := range []string{ingress.NoTLSVerifyFlag, tlsconfig.OriginCAPoolFlag, ingress.OriginServerNameFlag} {
+		if c.IsSet(flag) {
+			return errors.Errorf("--origin-h2c and --%s are contradictory: h2c connections to the origin don't use TLS", flag)
+		}
+	}
+	return nil
+}
+
+// newOriginH2CTransport speaks HTTP/2 with prior knowledge to a cleartext origin. Connections are dialed with
+// the origin transport's DialContext when they are opened, so the dial options and the byte and pool metrics
+// wrapped around it still apply.
+func newOriginH2CTransport(transport *http.Transport) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return transport.DialContext(context.Background(), network, addr)
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestOriginHTTP2(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.Proto) })
+	tlsOrigin := httptest.NewUnstartedServer(proto)
+	tlsOrigin.EnableHTTP2 = true
+	tlsOrigin.StartTLS()
+	defer tlsOrigin.Close()
+	h2cOrigin := httptest.NewServer(h2c.NewHandler(proto, &http2.Server{}))
+	defer h2cOrigin.Close()
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantProto string
+	}{
+		{name: "https origin", args: []string{"--url", tlsOrigin.URL, "--no-tls-verify", "--disable-origin-keepalive"}, wantProto: "HTTP/1.1"},
+		{name: "--origin-http2", args: []string{"--url", tlsOrigin.URL, "--no-tls-verify", "--origin-http2"}, wantProto: "HTTP/2.0"},
+		{name: "http origin", args: []string{"--url", h2cOrigin.URL, "--disable-origin-keepalive"}, wantProto: "HTTP/1.1"},
+		{name: "--origin-h2c", args: []string{"--url", h2cOrigin.URL, "--origin-h2c"}, wantProto: "HTTP/2.0"},
+		{name: "--origin-h2c with metrics", args: []string{"--url", h2cOrigin.URL, "--origin-h2c", "--origin-byte-metrics", "--origin-pool-metrics"}, wantProto: "HTTP/2.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy, err := NewOriginProxy(runContext(t, test.args...), testLog(), nopMetrics{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyURL, err := proxy.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.Close()
+			resp, err := http.Get(proxyURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != test.wantProto {
+				t.Fatalf("origin was reached over %q, want %s", body, test.wantProto)
+			}
+		})
+	}
+	if err := checkOriginReachable(runContext(t, "--url", h2cOrigin.URL, "--origin-h2c"), testLog()); err != nil {
+		t.Fatalf("--require-origin check failed with --origin-h2c: %v", err)
+	}
+}
+
+func TestValidateOriginHTTP2(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "neither", args: []string{"--url", "http://localhost:8080"}},
+		{name: "--origin-http2 with https", args: []string{"--url", "https://localhost:8443", "--origin-http2"}},
+		{name: "--origin-h2c with http", args: []string{"--url", "http://localhost:8080", "--origin-h2c"}},
+		{name: "both", args: []string{"--url", "http://localhost:8080", "--origin-http2", "--origin-h2c"}, wantErr: true},
+		{name: "--origin-http2 with http", args: []string{"--url", "http://localhost:8080", "--origin-http2"}, wantErr: true},
+		{name: "--origin-h2c with https", args: []string{"--url", "https://localhost:8443", "--origin-h2c"}, wantErr: true},
+		{name: "--origin-h2c with --no-tls-verify", args: []string{"--url", "http://localhost:8080", "--origin-h2c", "--no-tls-verify"}, wantErr: true},
+		{name: "--origin-h2c with --origin-ca-pool", args: []string{"--url", "http://localhost:8080", "--origin-h2c", "--origin-ca-pool", "ca.pem"}, wantErr: true},
+		{name: "--origin-h2c with --origin-server-name", args: []string{"--url", "http://localhost:8080", "--origin-h2c", "--origin-server-name", "example.com"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := runContext(t, test.args...)
+			if err := validateOriginHTTP2(c, mustParseURL(t, c.String("url"))); (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// originPoolStats reports how saturated the origin connection pool is. Connections are counted as they are
+// dialed and closed, and requests while they are in flight: requests beyond the open connections are
+// waiting for one, open connections beyond the requests are idle.
+type originPoolStats struct {
+	metrics MetricsRecorder
+
+	lock     sync.Mutex
+	open     int
+	inFlight int
+}
+
+func newOriginPoolStats(transport *http.Transport, metrics MetricsRecorder) *originPoolStats {
+	stats := &originPoolStats{metrics: metrics}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		stats.update(1, 0)
+		return &countedConn{Conn: conn, stats: stats}, nil
+	}
+	stats.update(0, 0)
+	return stats
+}
+
+// Wrap counts the requests sent through transport until their response body is closed.
+func (s *originPoolStats) Wrap(transport http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		s.update(0, 1)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			s.update(0, -1)
+			return nil, err
+		}
+		resp.Body = &countedBody{ReadCloser: resp.Body, stats: s}
+		return resp, nil
+	})
+}
+
+func (s *originPoolStats) update(open, inFlight int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.open += open
+	s.inFlight += inFlight
+	active := s.inFlight
+	if active > s.open {
+		active = s.open
+	}
+	s.metrics.SetGauge(metricOriginConnections, float64(active), map[string]string{"state": "active"})
+	s.metrics.SetGauge(metricOriginConnections, float64(s.open-active), map[string]string{"state": "idle"})
+	s.metrics.SetGauge(metricOriginConnections, float64(s.inFlight-active), map[string]string{"state": "waiting"})
+}
+
+type countedConn struct {
+	net.Conn
+	stats *originPoolStats
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.stats.update(-1, 0)
+	})
+	return c.Conn.Close()
+}
+
+type countedBody struct {
+	io.ReadCloser
+	stats *originPoolStats
+	once  sync.Once
+}
+
+func (b *countedBody) Close() error {
+	b.once.Do(func() {
+		b.stats.update(0, -1)
+	})
+	return b.ReadCloser.Close()
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
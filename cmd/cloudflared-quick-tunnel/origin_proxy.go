@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// OriginProxy is a local reverse proxy placed between cloudflared and the --url origin, so that requests
+// can be shaped before they reach the origin. cloudflared is pointed at the proxy's loopback listener.
+type OriginProxy struct {
+	origin    *url.URL
+	server    *http.Server
+	listener  net.Listener
+	accessLog *accessLogger
+	bytes     *originByteCounter
+	noTraffic *noTrafficAlert
+	log       *zerolog.Logger
+}
+
+// NewOriginProxy returns nil if none of the origin proxy options are in use, in which case cloudflared
+// connects to the origin directly.
+func NewOriginProxy(c *cli.Context, log *zerolog.Logger, metrics MetricsRecorder, summary *SummaryWebhook) (*OriginProxy, error) {
+	if !originProxyEnabled(c) {
+		return nil, nil
+	}
+	if c.IsSet("hello-world") || c.IsSet("unix-socket") || c.IsSet(ingress.Socks5Flag) {
+		return nil, errors.New("origin proxy options can only be used with an http(s) --url origin")
+	}
+	origin, err := url.Parse(c.String("url"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --url")
+	}
+	if origin.Scheme != "http" && origin.Scheme != "https" {
+		return nil, errors.Errorf("origin proxy options require an http or https --url, got %q", origin.Scheme)
+	}
+	if c.String("origin-min-tls-version") != "" && origin.Scheme != "https" {
+		return nil, errors.New("--origin-min-tls-version requires an https --url")
+	}
+	if c.String("origin-client-cert") != "" && origin.Scheme != "https" {
+		return nil, errors.New("--origin-client-cert requires an https --url")
+	}
+	if err := validateOriginHTTP2(c, origin); err != nil {
+		return nil, err
+	}
+	router, err := newOriginRouter(c.StringSlice("route"), origin)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range router.routes {
+		if c.Bool("origin-h2c") && route.origin.Scheme != "http" {
+			return nil, errors.Errorf("--origin-h2c requires http origins, --route %s is %s", route.prefix, route.origin)
+		}
+	}
+	if c.Bool("origin-sni-from-host") {
+		if origin.Scheme != "https" {
+			return nil, errors.New("--origin-sni-from-host requires an https --url")
+		}
+		if c.IsSet(ingress.OriginServerNameFlag) {
+			return nil, errors.Errorf("--origin-sni-from-host and --%s are contradictory", ingress.OriginServerNameFlag)
+		}
+	}
+
+	transport, err := newOriginTransport(c, log)
+	if err != nil {
+		return nil, err
+	}
+	var bytes *originByteCounter
+	if c.Bool("origin-byte-metrics") {
+		bytes = newOriginByteCounter(transport, metrics, c.Duration("metrics-update-freq"))
+	}
+	var roundTripper http.RoundTripper = transport
+	if c.Bool("origin-h2c") {
+		roundTripper = newOriginH2CTransport(transport)
+	}
+	if c.Bool("origin-sni-from-host") {
+		roundTripper = newHostSNITransport(transport)
+	}
+	if c.Bool("origin-pool-metrics") {
+		roundTripper = newOriginPoolStats(transport, metrics).Wrap(roundTripper)
+	}
+	if threshold := c.Int("origin-breaker-threshold"); threshold > 0 {
+		roundTripper = newOriginBreaker(roundTripper, threshold, c.Duration("origin-breaker-cooldown"), c.Duration("origin-breaker-window"), log)
+	}
+
+	requestHeaders, err := parseHeaderFlag("origin-request-header", c.StringSlice("origin-request-header"))
+	if err != nil {
+		return nil, err
+	}
+	responseHeaders, err := newResponseHeaders(c)
+	if err != nil {
+		return nil, err
+	}
+	accessLog, err := newAccessLogger(c)
+	if err != nil {
+		return nil, err
+	}
+	rewrite, err := newPathRewrite(c, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	injectTraceparent := c.Bool("inject-traceparent")
+	reverseProxy := &httputil.ReverseProxy{Transport: roundTripper}
+	reverseProxy.Director = func(r *http.Request) {
+		director := router.route(r.URL.Path)
+		rewrite.Request(r)
+		director(r)
+		if injectTraceparent {
+			ensureTraceparent(r.Header)
+		}
+		for key, values := range requestHeaders {
+			r.Header[key] = values
+		}
+	}
+	reverseProxy.ModifyResponse = func(resp *http.Response) error {
+		responseHeaders.Apply(resp.Header)
+		return rewrite.Response(resp)
+	}
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, errOriginBreakerOpen) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Warn().Str("path", r.URL.Path).Msg("Origin request timed out")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		if errors.Is(err, errRequestBodyTooLarge) {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Err(err).Str("path", r.URL.Path).Msg("Origin request failed")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	var handler http.Handler = reverseProxy
+	if timeout := c.Duration("origin-request-timeout"); timeout > 0 {
+		handler = timeoutRequest(handler, timeout)
+	}
+	if limit := c.Int64("max-request-body"); limit > 0 {
+		handler = limitRequestBody(handler, limit)
+	}
+	if max := c.Int("max-concurrent-requests"); max > 0 {
+		handler = newConcurrencyLimiter(max, c.Int("max-concurrent-queue"), c.Duration("max-concurrent-timeout")).Wrap(handler)
+	}
+	if rate := c.Float64("rate-limit"); rate > 0 {
+		handler = newRateLimiter(rate, c.Int("rate-limit-burst"), c.Bool("rate-limit-per-ip")).Wrap(handler)
+	}
+	if c.Bool("origin-websocket-only") {
+		handler = websocketOnly(handler)
+	}
+	var noTraffic *noTrafficAlert
+	if window := c.Duration("no-traffic-alert"); window > 0 {
+		noTraffic = newNoTrafficAlert(window, summary, log)
+		handler = noTraffic.Wrap(handler)
+	}
+	if accessLog != nil {
+		handler = accessLog.Wrap(handler)
+	}
+
+	return &OriginProxy{
+		origin:    origin,
+		server:    &http.Server{Handler: handler},
+		accessLog: accessLog,
+		bytes:     bytes,
+		noTraffic: noTraffic,
+		log:       log,
+	}, nil
+}
+
+func originProxyEnabled(c *cli.Context) bool {
+	return c.Int("origin-breaker-threshold") > 0 ||
+		len(c.StringSlice("origin-request-header")) > 0 ||
+		len(c.StringSlice("route")) > 0 ||
+		len(c.StringSlice("strip-response-header")) > 0 ||
+		len(c.StringSlice("add-response-header")) > 0 ||
+		c.String("access-log") != "" ||
+		c.Int64("max-request-body") > 0 ||
+		c.Float64("rate-limit") > 0 ||
+		c.Int("max-concurrent-requests") > 0 ||
+		c.Duration("no-traffic-alert") > 0 ||
+		c.Duration("origin-request-timeout") > 0 ||
+		c.Int("origin-max-conns") > 0 ||
+		c.Bool("disable-origin-keepalive") ||
+		c.Bool("origin-http2") ||
+		c.Bool("origin-h2c") ||
+		c.Bool("inject-traceparent") ||
+		c.Bool("origin-websocket-only") ||
+		c.String("origin-ip-version") != "auto" ||
+		c.Bool("origin-pool-metrics") ||
+		c.Bool("origin-byte-metrics") ||
+		c.Bool("origin-sni-from-host") ||
+		c.String("origin-min-tls-version") != "" ||
+		c.String("origin-client-cert") != "" ||
+		c.String("origin-path-prefix-strip") != "" ||
+		c.String("origin-path-prefix-add") != ""
+}
+
+// parseHeaderFlag parses the values of a header flag such as --origin-request-header, of the form "Key: Value".
+func parseHeaderFlag(flag string, values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, value := range values {
+		i := strings.Index(value, ":")
+		if i < 0 {
+			return nil, errors.Errorf("invalid --%s %q, expected \"Key: Value\"", flag, value)
+		}
+		key, headerValue := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+		if !httpguts.ValidHeaderFieldName(key) {
+			return nil, errors.Errorf("invalid --%s %q, %q is not a valid header name", flag, value, key)
+		}
+		if !httpguts.ValidHeaderFieldValue(headerValue) {
+			return nil, errors.Errorf("invalid --%s %q, header value contains invalid characters", flag, value)
+		}
+		headers.Add(key, headerValue)
+	}
+	return headers, nil
+}
+
+// newOriginTransport mirrors the transport cloudflared builds for an http origin from the proxy flags.
+func newOriginTransport(c *cli.Context, log *zerolog.Logger) (*http.Transport, error) {
+	originCertPool, err := loadOriginCAPool(c, log)
+	if err != nil {
+		return nil, err
+	}
+	minTLSVersion, err := parseTLSVersion(c.String("origin-min-tls-version"))
+	if err != nil {
+		return nil, err
+	}
+	clientCertificates, err := loadOriginClientCert(c)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          c.Int(ingress.ProxyKeepAliveConnectionsFlag),
+		MaxIdleConnsPerHost:   c.Int(ingress.ProxyKeepAliveConnectionsFlag),
+		MaxConnsPerHost:       c.Int("origin-max-conns"),
+		DisableKeepAlives:     c.Bool("disable-origin-keepalive"),
+		ForceAttemptHTTP2:     c.Bool("origin-http2"),
+		IdleConnTimeout:       c.Duration(ingress.ProxyKeepAliveTimeoutFlag),
+		TLSHandshakeTimeout:   c.Duration(ingress.ProxyTLSTimeoutFlag),
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			RootCAs:            originCertPool,
+			InsecureSkipVerify: c.Bool(ingress.NoTLSVerifyFlag),
+			ServerName:         c.String(ingress.OriginServerNameFlag),
+			MinVersion:         minTLSVersion,
+			Certificates:       clientCertificates,
+		},
+	}
+	dialer := &net.Dialer{
+		Timeout:   c.Duration(ingress.ProxyConnectTimeoutFlag),
+		KeepAlive: c.Duration(ingress.ProxyTCPKeepAliveFlag),
+	}
+	if c.Bool(ingress.ProxyNoHappyEyeballsFlag) {
+		dialer.FallbackDelay = -1 // As of Golang 1.12, a negative delay disables "happy eyeballs"
+	}
+	transport.DialContext = dialer.DialContext
+	if network := originDialNetwork(c.String("origin-ip-version")); network != "tcp" {
+		transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+	return transport, nil
+}
+
+// loadOriginClientCert loads the certificate the proxy authenticates to an origin that requires mTLS with,
+// from --origin-client-cert and --origin-client-key. It is nil if neither is set.
+func loadOriginClientCert(c *cli.Context) ([]tls.Certificate, error) {
+	certFile, keyFile := c.String("origin-client-cert"), c.String("origin-client-key")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--origin-client-cert and --origin-client-key must be set together")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --origin-client-cert or --origin-client-key")
+	}
+	return []tls.Certificate{certificate}, nil
+}
+
+// parseTLSVersion parses an --origin-min-tls-version. An empty version leaves Go's default minimum.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, errors.Errorf("invalid --origin-min-tls-version %q, expected 1.0, 1.1, 1.2 or 1.3", version)
+	}
+}
+
+// originDialNetwork is the network the origin is dialed on for --origin-ip-version.
+func originDialNetwork(ipVersion string) string {
+	switch ipVersion {
+	case "4":
+		return "tcp4"
+	case "6":
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// Start begins serving on a loopback port and returns the URL cloudflared should use as its origin.
+func (p *OriginProxy) Start() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start origin proxy")
+	}
+	if p.bytes != nil {
+		listener = p.bytes.Listener(listener)
+		go p.bytes.Run()
+	}
+	p.listener = listener
+	if p.noTraffic != nil {
+		p.noTraffic.Start()
+	}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.log.Err(err).Msg("Origin proxy stopped")
+		}
+	}()
+	proxyURL := "http://" + listener.Addr().String()
+	p.log.Info().Msgf("Proxying origin %s through %s", p.origin, proxyURL)
+	return proxyURL, nil
+}
+
+func (p *OriginProxy) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+	if p.noTraffic != nil {
+		p.noTraffic.Stop()
+	}
+	err := p.server.Shutdown(ctx)
+	if p.bytes != nil {
+		p.bytes.Close()
+	}
+	if p.accessLog != nil {
+		p.accessLog.Close()
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDisableOriginKeepalive(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		wantConnections int
+	}{
+		{name: "keepalive", wantConnections: 1},
+		{name: "--disable-origin-keepalive", args: []string{"--disable-origin-keepalive"}, wantConnections: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			connections := 0
+			origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			origin.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					lock.Lock()
+					connections++
+					lock.Unlock()
+				}
+			}
+			origin.Start()
+			defer origin.Close()
+			// --origin-request-timeout only turns the origin proxy on
+			args := append([]string{"--url", origin.URL, "--origin-request-timeout", "10s"}, test.args...)
+			proxy, err := NewOriginProxy(runContext(t, args...), testLog(), nopMetrics{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyURL, err := proxy.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.Close()
+			for i := 0; i < 3; i++ {
+				resp, err := http.Get(proxyURL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if connections != test.wantConnections {
+				t.Fatalf("origin got %d connections for 3 requests, want %d", connections, test.wantConnections)
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "", want: 0},
+		{version: "1.0", want: tls.VersionTLS10},
+		{version: "1.1", want: tls.VersionTLS11},
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "1.4", wantErr: true},
+		{version: "TLS1.2", wantErr: true},
+		{version: "1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseTLSVersion(test.version)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseTLSVersion(%q) = %x, %v, want %x, error %v", test.version, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// The origin only speaks TLS 1.1, which a minimum above it refuses.
+func TestOriginMinTLSVersion(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS11}
+	origin.StartTLS()
+	defer origin.Close()
+
+	tests := []struct {
+		version    string
+		wantStatus int
+	}{
+		{version: "1.0", wantStatus: http.StatusOK},
+		{version: "1.1", wantStatus: http.StatusOK},
+		{version: "1.2", wantStatus: http.StatusBadGateway},
+		{version: "1.3", wantStatus: http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			proxy, err := NewOriginProxy(runContext(t, "--url", origin.URL, "--no-tls-verify", "--origin-min-tls-version", test.version), testLog(), nopMetrics{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyURL, err := proxy.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.Close()
+			resp, err := http.Get(proxyURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+		})
+	}
+	if _, err := NewOriginProxy(runContext(t, "--url", "http://localhost:8080", "--origin-min-tls-version", "1.2"), testLog(), nopMetrics{}, nil); err == nil {
+		t.Fatal("expected --origin-min-tls-version to be rejected for an http origin")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// originRoute sends the requests under a path prefix to an origin of its own.
+type originRoute struct {
+	prefix   string
+	origin   *url.URL
+	director func(*http.Request)
+}
+
+// originRouter picks the origin of each request by its public path from the --route flags, falling back to
+// the --url origin. Prefixes match whole path segments, as in the path rewrite, and the longest match wins.
+// The path is sent on as it is: --origin-path-prefix-strip removes a prefix the origin doesn't expect.
+type originRouter struct {
+	routes   []originRoute
+	fallback func(*http.Request)
+}
+
+// newOriginRouter returns a router over the --route flags, of the form "/PREFIX=URL".
+func newOriginRouter(routes []string, fallback *url.URL) (*originRouter, error) {
+	router := &originRouter{fallback: httputil.NewSingleHostReverseProxy(fallback).Director}
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		i := strings.Index(route, "=")
+		if i < 0 {
+			return nil, errors.Errorf("invalid --route %q, expected \"/PREFIX=URL\"", route)
+		}
+		prefix, target := route[:i], route[i+1:]
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, errors.Errorf("invalid --route %q, the prefix must start with /", route)
+		}
+		prefix = strings.TrimRight(prefix, "/")
+		if seen[prefix] {
+			return nil, errors.Errorf("invalid --route %q, another route has the same prefix", route)
+		}
+		seen[prefix] = true
+		origin, err := url.Parse(target)
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" {
+			return nil, errors.Errorf("invalid --route %q, expected an http or https origin URL", route)
+		}
+		router.routes = append(router.routes, originRoute{
+			prefix:   prefix,
+			origin:   origin,
+			director: httputil.NewSingleHostReverseProxy(origin).Director,
+		})
+	}
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+	})
+	return router, nil
+}
+
+// route returns the director of the origin for a public path.
+func (r *originRouter) route(path string) func(*http.Request) {
+	for _, route := range r.routes {
+		if route.prefix == "" || path == route.prefix || strings.HasPrefix(path, route.prefix+"/") {
+			return route.director
+		}
+	}
+	return r.fallback
+}
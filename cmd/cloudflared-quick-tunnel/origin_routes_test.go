@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginRouterRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []string
+		path     string
+		wantHost string
+	}{
+		{name: "no routes", path: "/api/users", wantHost: "default:8080"},
+		{name: "prefix match", routes: []string{"/api=http://api:9000"}, path: "/api/users", wantHost: "api:9000"},
+		{name: "exact prefix", routes: []string{"/api=http://api:9000"}, path: "/api", wantHost: "api:9000"},
+		{name: "whole segments only", routes: []string{"/api=http://api:9000"}, path: "/apis", wantHost: "default:8080"},
+		{name: "longest match wins", routes: []string{"/api=http://api:9000", "/api/v2=http://v2:9002"}, path: "/api/v2/users", wantHost: "v2:9002"},
+		{name: "longest match given first", routes: []string{"/api/v2=http://v2:9002", "/api=http://api:9000"}, path: "/api/v1", wantHost: "api:9000"},
+		{name: "root route replaces --url", routes: []string{"/=http://root:8081", "/api=http://api:9000"}, path: "/other", wantHost: "root:8081"},
+		{name: "trailing slash in prefix", routes: []string{"/api/=https://api:9443"}, path: "/api/x", wantHost: "api:9443"},
+	}
+	fallback := mustParseURL(t, "http://default:8080")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router, err := newOriginRouter(test.routes, fallback)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			router.route(r.URL.Path)(r)
+			if r.URL.Host != test.wantHost {
+				t.Errorf("%s routed to %s, want %s", test.path, r.URL.Host, test.wantHost)
+			}
+			if r.URL.Path != test.path {
+				t.Errorf("path changed to %s", r.URL.Path)
+			}
+		})
+	}
+}
+
+func TestOriginRouterInvalidRoutes(t *testing.T) {
+	for _, route := range []string{
+		"/api",
+		"api=http://localhost:9000",
+		"/api=localhost:9000",
+		"/api=tcp://localhost:9000",
+		"/api=http://",
+	} {
+		if _, err := newOriginRouter([]string{route}, mustParseURL(t, "http://localhost:8080")); err == nil {
+			t.Errorf("--route %q was accepted", route)
+		}
+	}
+	if _, err := newOriginRouter([]string{"/api=http://a:1", "/api/=http://b:2"}, mustParseURL(t, "http://localhost:8080")); err == nil {
+		t.Error("duplicate prefixes were accepted")
+	}
+}
+
+func TestOriginProxyRoutes(t *testing.T) {
+	newOrigin := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	api, web := newOrigin("api"), newOrigin("web")
+	defer api.Close()
+	defer web.Close()
+
+	proxy, err := NewOriginProxy(runContext(t, "--url", web.URL, "--route", "/api="+api.URL), testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	for path, want := range map[string]string{"/api/users": "api /api/users", "/index.html": "web /index.html"} {
+		resp, err := http.Get(proxyURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s answered %q, want %q", path, body, want)
+		}
+	}
+}
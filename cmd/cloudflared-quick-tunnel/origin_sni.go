@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// hostSNITransport sends each request to the origin with the hostname of its Host header as the TLS server
+// name, as requested by --origin-sni-from-host. That Host is what --http-host-header set it to, or else the
+// tunnel's public hostname. Connections are only reused for the same server name, so there is a copy of
+// the origin transport for each Host seen.
+type hostSNITransport struct {
+	base *http.Transport
+
+	lock       sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func newHostSNITransport(base *http.Transport) *hostSNITransport {
+	return &hostSNITransport{base: base, transports: make(map[string]*http.Transport)}
+}
+
+func (t *hostSNITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport(requestHostname(req)).RoundTrip(req)
+}
+
+func (t *hostSNITransport) transport(serverName string) *http.Transport {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	transport, ok := t.transports[serverName]
+	if !ok {
+		transport = t.base.Clone()
+		transport.TLSClientConfig.ServerName = serverName
+		t.transports[serverName] = transport
+	}
+	return transport
+}
+
+// requestHostname is the Host the request is sent with, without its port.
+func requestHostname(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Go never sends an IP address as the TLS server name, so an https://<ip> origin gets no SNI unless
+// --origin-server-name sets one. The httptest certificate is valid for 127.0.0.1 and example.com.
+func TestOriginTransportIPOrigin(t *testing.T) {
+	var lock sync.Mutex
+	var serverName string
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		lock.Lock()
+		serverName = hello.ServerName
+		lock.Unlock()
+		return nil, nil
+	}}
+	origin.StartTLS()
+	defer origin.Close()
+	if !strings.HasPrefix(origin.URL, "https://127.0.0.1:") {
+		t.Fatalf("expected an IP origin, got %s", origin.URL)
+	}
+	caPool := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caPool, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		args           []string
+		wantServerName string
+	}{
+		{name: "IP origin sends no SNI", wantServerName: ""},
+		{name: "--origin-server-name sets SNI", args: []string{"--origin-server-name", "example.com"}, wantServerName: "example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"--url", origin.URL, "--origin-ca-pool", caPool}, test.args...)
+			transport, err := newOriginTransport(runContext(t, args...), testLog())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get(origin.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			lock.Lock()
+			defer lock.Unlock()
+			if serverName != test.wantServerName {
+				t.Fatalf("origin received server name %q, want %q", serverName, test.wantServerName)
+			}
+		})
+	}
+}
+
+func TestRequestHostname(t *testing.T) {
+	tests := []struct {
+		host    string
+		urlHost string
+		want    string
+	}{
+		{host: "example.com", want: "example.com"},
+		{host: "example.com:8443", want: "example.com"},
+		{host: "[::1]:8443", want: "::1"},
+		{host: "[::1]", want: "::1"},
+		{urlHost: "origin.internal:8443", want: "origin.internal"},
+	}
+	for _, test := range tests {
+		req := &http.Request{Host: test.host, URL: &url.URL{Host: test.urlHost}}
+		if got := requestHostname(req); got != test.want {
+			t.Errorf("requestHostname(Host %q, URL host %q) = %q, want %q", test.host, test.urlHost, got, test.want)
+		}
+	}
+}
+
+// The httptest certificate is valid for example.com, so the server name picks whether the origin is trusted.
+func TestOriginSNIFromHost(t *testing.T) {
+	var lock sync.Mutex
+	var serverName string
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		lock.Lock()
+		serverName = hello.ServerName
+		lock.Unlock()
+		return nil, nil
+	}}
+	origin.StartTLS()
+	defer origin.Close()
+	caPool := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caPool, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewOriginProxy(runContext(t, "--url", origin.URL, "--origin-sni-from-host", "--origin-ca-pool", caPool), testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	tests := []struct {
+		host       string
+		wantStatus int
+	}{
+		{host: "example.com", wantStatus: http.StatusOK},
+		{host: "example.com:443", wantStatus: http.StatusOK},
+		{host: "other.invalid", wantStatus: http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, proxyURL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = test.host
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if want := strings.Split(test.host, ":")[0]; serverName != want {
+				t.Fatalf("origin received server name %q, want %q", serverName, want)
+			}
+		})
+	}
+}
+
+func TestOriginSNIFromHostValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "http origin", args: []string{"--url", "http://localhost:8080", "--origin-sni-from-host"}},
+		{name: "with --origin-server-name", args: []string{"--url", "https://localhost:8443", "--origin-sni-from-host", "--origin-server-name", "example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewOriginProxy(runContext(t, test.args...), testLog(), nopMetrics{}, nil); err == nil {
+				t.Fatal("expected the options to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// pathRewrite maps public request paths to origin paths: --origin-path-prefix-strip is removed from the
+// start of the path, then --origin-path-prefix-add is put in front. Prefixes match whole path segments,
+// so stripping /app leaves /application alone. Its methods do nothing on a nil pathRewrite.
+type pathRewrite struct {
+	strip           string
+	add             string
+	rewriteLocation bool
+	originHost      string
+}
+
+func newPathRewrite(c *cli.Context, origin *url.URL) (*pathRewrite, error) {
+	strip, err := pathPrefix(c, "origin-path-prefix-strip")
+	if err != nil {
+		return nil, err
+	}
+	add, err := pathPrefix(c, "origin-path-prefix-add")
+	if err != nil {
+		return nil, err
+	}
+	if strip == "" && add == "" {
+		return nil, nil
+	}
+	return &pathRewrite{
+		strip:           strip,
+		add:             add,
+		rewriteLocation: c.Bool("origin-path-rewrite-location"),
+		originHost:      origin.Host,
+	}, nil
+}
+
+// pathPrefix reads a prefix flag without its trailing slash, so "/" is the same as no prefix.
+func pathPrefix(c *cli.Context, name string) (string, error) {
+	prefix := c.String(name)
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", errors.Errorf("invalid --%s %q, it must start with /", name, prefix)
+	}
+	return strings.TrimRight(prefix, "/"), nil
+}
+
+func (p *pathRewrite) Request(r *http.Request) {
+	if p == nil {
+		return
+	}
+	r.URL.Path = replacePathPrefix(r.URL.Path, p.strip, p.add)
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = replacePathPrefix(r.URL.RawPath, p.strip, p.add)
+	}
+}
+
+// Response maps the path of a redirect back from the origin's paths to the public ones, for redirects to a
+// path or to the host the request was made to. A redirect to the origin's own host is made relative, since
+// that host isn't reachable through the tunnel.
+func (p *pathRewrite) Response(resp *http.Response) error {
+	if p == nil || !p.rewriteLocation {
+		return nil
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case u.Host == p.originHost:
+		u.Scheme, u.Host, u.User = "", "", nil
+	case u.Host == "" && !strings.HasPrefix(u.Path, "/"):
+		// Relative to the request path, which is the same on both sides
+		return nil
+	case u.Host != "" && (resp.Request == nil || u.Host != resp.Request.Host):
+		// Somewhere else entirely
+		return nil
+	}
+	u.Path = replacePathPrefix(u.Path, p.add, p.strip)
+	if u.RawPath != "" {
+		u.RawPath = replacePathPrefix(u.RawPath, p.add, p.strip)
+	}
+	resp.Header.Set("Location", u.String())
+	return nil
+}
+
+// replacePathPrefix replaces the from prefix of path with to. Paths that don't start with from are only
+// given the to prefix.
+func replacePathPrefix(path, from, to string) string {
+	if from != "" {
+		if path == from {
+			path = "/"
+		} else if strings.HasPrefix(path, from+"/") {
+			path = path[len(from):]
+		}
+	}
+	if to == "" {
+		return path
+	}
+	if path == "/" || path == "" {
+		return to + "/"
+	}
+	return to + path
+}
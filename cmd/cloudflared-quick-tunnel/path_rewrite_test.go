@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplacePathPrefix(t *testing.T) {
+	tests := []struct {
+		path, from, to string
+		want           string
+	}{
+		{path: "/x", to: "/app", want: "/app/x"},
+		{path: "/", to: "/app", want: "/app/"},
+		{path: "", to: "/app", want: "/app/"},
+		{path: "/public/x", from: "/public", want: "/x"},
+		{path: "/public", from: "/public", want: "/"},
+		{path: "/publicity", from: "/public", want: "/publicity"},
+		{path: "/other", from: "/public", want: "/other"},
+		{path: "/public/x", from: "/public", to: "/app", want: "/app/x"},
+		{path: "/public", from: "/public", to: "/app", want: "/app/"},
+		{path: "/other", from: "/public", to: "/app", want: "/app/other"},
+	}
+	for _, test := range tests {
+		if got := replacePathPrefix(test.path, test.from, test.to); got != test.want {
+			t.Errorf("replacePathPrefix(%q, %q, %q) = %q, want %q", test.path, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestNewPathRewrite(t *testing.T) {
+	origin := mustParseURL(t, "http://localhost:8080")
+	tests := []struct {
+		name     string
+		args     []string
+		wantNil  bool
+		wantErr  bool
+		wantFrom string
+		wantTo   string
+	}{
+		{name: "no prefixes", wantNil: true},
+		{name: "root prefix is none", args: []string{"--origin-path-prefix-strip", "/"}, wantNil: true},
+		{name: "trailing slash", args: []string{"--origin-path-prefix-strip", "/public/", "--origin-path-prefix-add", "/app/"}, wantFrom: "/public", wantTo: "/app"},
+		{name: "relative strip", args: []string{"--origin-path-prefix-strip", "public"}, wantErr: true},
+		{name: "relative add", args: []string{"--origin-path-prefix-add", "app"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rewrite, err := newPathRewrite(runContext(t, test.args...), origin)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if (rewrite == nil) != test.wantNil {
+				t.Fatalf("got rewrite %+v, want nil %v", rewrite, test.wantNil)
+			}
+			if rewrite != nil && (rewrite.strip != test.wantFrom || rewrite.add != test.wantTo) {
+				t.Fatalf("got strip %q and add %q, want %q and %q", rewrite.strip, rewrite.add, test.wantFrom, test.wantTo)
+			}
+		})
+	}
+}
+
+func TestPathRewriteLocation(t *testing.T) {
+	rewrite := &pathRewrite{strip: "/public", add: "/app", rewriteLocation: true, originHost: "localhost:8080"}
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{location: "/app/login", want: "/public/login"},
+		{location: "http://localhost:8080/app/login", want: "/public/login"},
+		{location: "https://a.trycloudflare.com/app/login?next=%2F", want: "https://a.trycloudflare.com/public/login?next=%2F"},
+		{location: "login", want: "login"},
+		{location: "https://elsewhere.example.com/app/login", want: "https://elsewhere.example.com/app/login"},
+		{location: "/login", want: "/public/login"},
+	}
+	for _, test := range tests {
+		resp := &http.Response{
+			Header:  http.Header{"Location": {test.location}},
+			Request: httptest.NewRequest(http.MethodGet, "https://a.trycloudflare.com/public/", nil),
+		}
+		if err := rewrite.Response(resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Location"); got != test.want {
+			t.Errorf("Location %q was rewritten to %q, want %q", test.location, got, test.want)
+		}
+	}
+}
+
+func TestOriginProxyPathRewrite(t *testing.T) {
+	var seen string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Path
+		http.Redirect(w, r, "http://"+r.Host+"/app/login", http.StatusFound)
+	}))
+	defer origin.Close()
+	c := runContext(t, "--url", origin.URL, "--origin-path-prefix-strip", "/public", "--origin-path-prefix-add", "/app")
+	proxy, err := NewOriginProxy(c, testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(proxyURL + "/public/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if seen != "/app/a/b" {
+		t.Errorf("origin received %s, want /app/a/b", seen)
+	}
+	if location := resp.Header.Get("Location"); location != proxyURL+"/public/login" {
+		t.Errorf("got Location %s, want %s/public/login", location, proxyURL)
+	}
+}
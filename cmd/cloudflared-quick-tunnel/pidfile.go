@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// How often writePidFile tries to replace a stale pidfile before giving up, if another process keeps
+// replacing it at the same time.
+const pidFileAttempts = 3
+
+// writePidFile records the process ID at path. The file is created exclusively, so two processes starting at
+// once can't both take it. An existing pidfile is only replaced if the process it names is no longer running,
+// or if force is set.
+func writePidFile(path string, force bool, log *zerolog.Logger) error {
+	expandedPath, err := homedir.Expand(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to expand pidfile path %s", path)
+	}
+	for attempt := 1; ; attempt++ {
+		file, err := os.OpenFile(expandedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return errors.Wrapf(err, "failed to write pidfile %s", expandedPath)
+		}
+		if !os.IsExist(err) || attempt == pidFileAttempts {
+			return errors.Wrapf(err, "failed to create pidfile %s", expandedPath)
+		}
+		content, err := ioutil.ReadFile(expandedPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read pidfile %s", expandedPath)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		switch {
+		case err != nil:
+			log.Warn().Msgf("Replacing unreadable pidfile %s", expandedPath)
+		case pid != os.Getpid() && processAlive(pid):
+			if !force {
+				return errors.Errorf("pidfile %s belongs to running process %d, use --force-pidfile to start anyway", expandedPath, pid)
+			}
+			log.Warn().Msgf("Replacing pidfile %s of running process %d", expandedPath, pid)
+		default:
+			log.Info().Msgf("Replacing stale pidfile %s", expandedPath)
+		}
+		// Another process may have replaced the file since it was read, in which case its pidfile is checked again
+		if current, err := ioutil.ReadFile(expandedPath); err == nil && !bytes.Equal(current, content) {
+			continue
+		}
+		if err := os.Remove(expandedPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to replace pidfile %s", expandedPath)
+		}
+	}
+}
+
+func removePidFile(path string, log *zerolog.Logger) {
+	expandedPath, err := homedir.Expand(path)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(expandedPath); err != nil && !os.IsNotExist(err) {
+		log.Err(err).Msgf("Failed to remove pidfile %s", expandedPath)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestWritePidFile(t *testing.T) {
+	// init is always running, and is owned by another user unless the tests run as root. Pids this high
+	// aren't handed out.
+	const runningPid, stalePid = "1", "2147483646"
+	tests := []struct {
+		name     string
+		existing string
+		force    bool
+		wantErr  bool
+	}{
+		{name: "no pidfile"},
+		{name: "stale pidfile", existing: stalePid},
+		{name: "unreadable pidfile", existing: "not a pid"},
+		{name: "own pidfile", existing: strconv.Itoa(os.Getpid())},
+		{name: "running process", existing: runningPid, wantErr: true},
+		{name: "running process with force", existing: runningPid, force: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "quick-tunnel.pid")
+			if test.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(test.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := writePidFile(path, test.force, testLog())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			content, readErr := ioutil.ReadFile(path)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			want := strconv.Itoa(os.Getpid())
+			if test.wantErr {
+				want = test.existing
+			}
+			if string(content) != want {
+				t.Fatalf("pidfile holds %q, want %q", content, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// FindProcess only succeeds on Windows if the process exists.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
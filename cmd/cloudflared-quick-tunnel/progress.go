@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Startup phases reported by --progress-json, in the order they happen. A stored tunnel skips the first four.
+const (
+	phaseRequestingTunnel   = "requesting_tunnel"
+	phaseTunnelCreated      = "tunnel_created"
+	phaseCallbackSent       = "callback_sent"
+	phaseCredentialsWritten = "credentials_written"
+	phaseConnectingEdge     = "connecting_edge"
+	phaseConnected          = "connected"
+	phaseReady              = "ready"
+)
+
+type ProgressEvent struct {
+	Phase string    `json:"phase"`
+	URL   string    `json:"url,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// progressStream writes a JSON line to stderr for each startup phase, for UIs that show progress without
+// parsing the log. It is independent of --log-format. The tunnel is connected on its first registered
+// connection and ready once all --ha-connections are, told apart by the connIndex cloudflared logs them with so
+// a connection registering again doesn't count twice. Its methods do nothing on a nil progressStream.
+type progressStream struct {
+	haConnections int
+	writer        io.Writer
+
+	lock      sync.Mutex
+	url       string
+	connected bool
+	// Indexes of the connections that registered
+	registered map[int]bool
+}
+
+// newProgressStream returns nil unless --progress-json is set.
+func newProgressStream(c *cli.Context) *progressStream {
+	if !c.Bool("progress-json") {
+		return nil
+	}
+	return &progressStream{haConnections: c.Int("ha-connections"), writer: os.Stderr}
+}
+
+// SetURL sets the tunnel URL reported from then on.
+func (p *progressStream) SetURL(url string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.url = url
+}
+
+func (p *progressStream) Emit(phase string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.emit(phase)
+}
+
+func (p *progressStream) emit(phase string) {
+	line, _ := json.Marshal(ProgressEvent{Phase: phase, URL: p.url, Time: time.Now().UTC()})
+	p.writer.Write(append(line, '\n'))
+}
+
+// Run is a zerolog hook that reports the connected and ready phases from cloudflared's registered connections.
+func (p *progressStream) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if !connectionRegistered.MatchString(msg) {
+		return
+	}
+	index, ok := logConnIndex(e)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.connected {
+		p.connected = true
+		p.emit(phaseConnected)
+	}
+	if !ok || p.registered[index] {
+		return
+	}
+	if p.registered == nil {
+		p.registered = make(map[int]bool)
+	}
+	p.registered[index] = true
+	if len(p.registered) == p.haConnections {
+		p.emit(phaseReady)
+	}
+}
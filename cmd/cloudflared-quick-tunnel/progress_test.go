@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestProgressStream(t *testing.T) {
+	tests := []struct {
+		name          string
+		haConnections int
+		// Registered connections by their index
+		connections []int
+		want        []ProgressEvent
+	}{
+		{
+			name:          "connected then ready",
+			haConnections: 2,
+			connections:   []int{0, 1},
+			want:          []ProgressEvent{{Phase: phaseConnected, URL: "https://a.trycloudflare.com"}, {Phase: phaseReady, URL: "https://a.trycloudflare.com"}},
+		},
+		{
+			name:          "single connection is connected and ready",
+			haConnections: 1,
+			connections:   []int{0},
+			want:          []ProgressEvent{{Phase: phaseConnected, URL: "https://a.trycloudflare.com"}, {Phase: phaseReady, URL: "https://a.trycloudflare.com"}},
+		},
+		{
+			name:          "reconnections are not reported",
+			haConnections: 1,
+			connections:   []int{0, 0},
+			want:          []ProgressEvent{{Phase: phaseConnected, URL: "https://a.trycloudflare.com"}, {Phase: phaseReady, URL: "https://a.trycloudflare.com"}},
+		},
+		{
+			name:          "not connected yet",
+			haConnections: 4,
+			want:          nil,
+		},
+		{
+			name:          "re-registered connection isn't ready early",
+			haConnections: 2,
+			connections:   []int{0, 0},
+			want:          []ProgressEvent{{Phase: phaseConnected, URL: "https://a.trycloudflare.com"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			progress := &progressStream{haConnections: test.haConnections, writer: &out}
+			progress.SetURL("https://a.trycloudflare.com")
+			logConnection(progress, zerolog.InfoLevel, "Starting metrics server", 0)
+			logConnection(progress, zerolog.ErrorLevel, "Retrying connection in up to 1s seconds", 0)
+			for i, index := range test.connections {
+				logConnection(progress, zerolog.InfoLevel, fmt.Sprintf("Connection %d%c registered", index, 'a'+i), index)
+			}
+			got := decodeProgress(t, &out)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d events %v, want %v", len(got), got, test.want)
+			}
+			for i := range got {
+				if got[i].Phase != test.want[i].Phase || got[i].URL != test.want[i].URL || got[i].Time.IsZero() {
+					t.Errorf("event %d = %+v, want %+v with a time", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProgressStreamNil(t *testing.T) {
+	var progress *progressStream
+	progress.SetURL("https://a.trycloudflare.com")
+	progress.Emit(phaseConnectingEdge)
+}
+
+func decodeProgress(t *testing.T, out *bytes.Buffer) []ProgressEvent {
+	t.Helper()
+	var events []ProgressEvent
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var event ProgressEvent
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
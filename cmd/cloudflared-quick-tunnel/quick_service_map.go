@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Where trycloudflare.com puts each field of a quick tunnel in its response, as dot-separated JSON paths.
+var defaultQuickServiceFields = map[string]string{
+	"id":          "result.id",
+	"name":        "result.name",
+	"hostname":    "result.hostname",
+	"account_tag": "result.account_tag",
+	"secret":      "result.secret",
+}
+
+// Fields a quick-service response can't do without. The name is only informative.
+var requiredQuickServiceFields = []string{"id", "hostname", "account_tag", "secret"}
+
+// quickServiceResponseMap reads a quick tunnel from a self-hosted quick-service whose response doesn't have
+// trycloudflare's shape. --response-map gives the JSON path of a field as "FIELD=PATH", such as
+// hostname=tunnel.host, and fields it doesn't mention are read from where trycloudflare has them.
+type quickServiceResponseMap map[string]string
+
+// parseQuickServiceResponseMap returns nil without any --response-map, for responses read as trycloudflare's.
+func parseQuickServiceResponseMap(values []string) (quickServiceResponseMap, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	fields := make(quickServiceResponseMap, len(defaultQuickServiceFields))
+	for field, path := range defaultQuickServiceFields {
+		fields[field] = path
+	}
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i < 0 {
+			return nil, errors.Errorf("invalid --response-map %q, expected \"FIELD=PATH\"", value)
+		}
+		field, path := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+		if _, ok := defaultQuickServiceFields[field]; !ok {
+			return nil, errors.Errorf("invalid --response-map %q, the field must be one of %s", value, strings.Join(quickServiceFieldNames(), ", "))
+		}
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, errors.Errorf("invalid --response-map %q, expected a dot-separated JSON path such as tunnel.%s", value, field)
+		}
+		fields[field] = path
+	}
+	return fields, nil
+}
+
+func quickServiceFieldNames() []string {
+	names := make([]string, 0, len(defaultQuickServiceFields))
+	for field := range defaultQuickServiceFields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decode reads the quick tunnel from a response body. The secret is the base64 of its bytes, as
+// trycloudflare sends it.
+func (m quickServiceResponseMap) decode(body []byte) (QuickTunnel, error) {
+	var tunnel QuickTunnel
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return tunnel, err
+	}
+	strs := make(map[string]string, len(m))
+	for field, path := range m {
+		fieldValue, ok := lookupJSONPath(value, path)
+		if !ok {
+			continue
+		}
+		str, ok := fieldValue.(string)
+		if !ok {
+			return tunnel, errors.Errorf("--response-map %s=%s is not a string in the quick-service response", field, path)
+		}
+		strs[field] = str
+	}
+	for _, field := range requiredQuickServiceFields {
+		if strs[field] == "" {
+			return tunnel, errors.Errorf("--response-map %s=%s is missing from the quick-service response", field, m[field])
+		}
+	}
+	secret, err := base64.StdEncoding.DecodeString(strs["secret"])
+	if err != nil {
+		return tunnel, errors.Wrapf(err, "--response-map secret=%s is not base64 in the quick-service response", m["secret"])
+	}
+	return QuickTunnel{
+		ID:         strs["id"],
+		Name:       strs["name"],
+		Hostname:   strs["hostname"],
+		AccountTag: strs["account_tag"],
+		Secret:     secret,
+	}, nil
+}
+
+// lookupJSONPath finds the value at a dot-separated list of keys, such as data.short_url, in decoded JSON.
+func lookupJSONPath(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
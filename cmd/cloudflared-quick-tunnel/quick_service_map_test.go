@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseQuickServiceResponseMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "no map", values: nil, want: nil},
+		{
+			name:   "unmapped fields keep trycloudflare paths",
+			values: []string{"hostname=tunnel.host", "secret = tunnel.credentials.secret"},
+			want: map[string]string{
+				"id":          "result.id",
+				"name":        "result.name",
+				"hostname":    "tunnel.host",
+				"account_tag": "result.account_tag",
+				"secret":      "tunnel.credentials.secret",
+			},
+		},
+		{name: "missing =", values: []string{"hostname"}, wantErr: true},
+		{name: "unknown field", values: []string{"url=tunnel.url"}, wantErr: true},
+		{name: "empty path", values: []string{"hostname="}, wantErr: true},
+		{name: "empty key in path", values: []string{"hostname=tunnel..host"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseQuickServiceResponseMap(test.values)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for field, path := range test.want {
+				if got[field] != path {
+					t.Errorf("%s = %q, want %q", field, got[field], path)
+				}
+			}
+		})
+	}
+}
+
+func TestQuickServiceResponseMapDecode(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	responseMap, err := parseQuickServiceResponseMap([]string{"id=tunnel.uuid", "hostname=tunnel.host", "account_tag=account", "secret=tunnel.secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "all fields", body: `{"account":"acc","tunnel":{"uuid":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","host":"a.example.com","secret":"` + secret + `"}}`},
+		{name: "missing hostname", body: `{"account":"acc","tunnel":{"uuid":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","secret":"` + secret + `"}}`, wantErr: true},
+		{name: "number instead of string", body: `{"account":12,"tunnel":{"uuid":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","host":"a.example.com","secret":"` + secret + `"}}`, wantErr: true},
+		{name: "secret not base64", body: `{"account":"acc","tunnel":{"uuid":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","host":"a.example.com","secret":"not base64!"}}`, wantErr: true},
+		{name: "not JSON", body: `<html></html>`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tunnel, err := responseMap.decode([]byte(test.body))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && (tunnel.Hostname != "a.example.com" || tunnel.AccountTag != "acc" || string(tunnel.Secret) != "0123456789abcdef0123456789abcdef") {
+				t.Errorf("decoded %+v", tunnel)
+			}
+		})
+	}
+}
+
+func TestRequestNewQuickTunnelResponseMap(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"tunnel":{"uuid":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","host":"a.example.com","account":"acc","secret":"c2VjcmV0"}}`))
+	}))
+	defer service.Close()
+
+	c := runContext(t, "--quick-service", service.URL,
+		"--response-map", "id=tunnel.uuid", "--response-map", "hostname=tunnel.host",
+		"--response-map", "account_tag=tunnel.account", "--response-map", "secret=tunnel.secret")
+	config, err := RequestNewQuickTunnel(c, testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != "a.example.com" || config.Credentials.AccountTag != "acc" || string(config.Credentials.TunnelSecret) != "secret" {
+		t.Errorf("got %+v", config)
+	}
+
+	// Without the map the response is read as trycloudflare's, which it isn't
+	if _, err := RequestNewQuickTunnel(runContext(t, "--quick-service", service.URL), testLog()); err == nil {
+		t.Error("a response without trycloudflare's fields was accepted")
+	}
+}
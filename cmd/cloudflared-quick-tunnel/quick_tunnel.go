@@ -3,24 +3,38 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	cli "github.com/urfave/cli/v2"
+	"golang.org/x/term"
 
-	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/tunnel"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/logger"
 )
 
 const httpTimeout = 15 * time.Second
 
+const (
+	ansiBoldCyan = "\x1b[1;36m"
+	ansiReset    = "\x1b[0m"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
 const disclaimer = "Thank you for trying Cloudflare Tunnel. Doing so, without a Cloudflare account, is a quick way to" +
 	" experiment and try it out. However, be aware that these account-less Tunnels have no uptime guarantee. If you " +
 	"intend to use Tunnels in production you should use a pre-created named tunnel by following: " +
@@ -29,76 +43,365 @@ const disclaimer = "Thank you for trying Cloudflare Tunnel. Doing so, without a
 // RunPersistentQuickTunnel requests a tunnel from the specified service.
 // We use this to power quick tunnels on trycloudflare.com, but the
 // service is open-source and could be used by anyone.
-func RunPersistentQuickTunnel(c *cli.Context, log *zerolog.Logger, version string) error {
+func RunPersistentQuickTunnel(c *cli.Context, log *zerolog.Logger, version string, graceShutdownC chan struct{}) (err error) {
+	if err := validateRunFlags(c, log); err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	stopShutdownTimer := startShutdownTimer(shutdownTimeout(c), graceShutdownC, log, os.Exit)
+	defer stopShutdownTimer()
+	if !c.IsSet("protocol") {
+		c.Set("protocol", "quic")
+	}
+	metrics, err := NewMetricsRecorder(c, log)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	defer metrics.Close()
+	callbacks, err := NewCallbackGroup(c, log, metrics)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	shutdownCallbacks, err := newShutdownCallbacks(c, callbacks, log, graceShutdownC)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	summary, err := NewSummaryWebhook(c, log, callbacks.token)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	defer func() {
+		if err != nil {
+			summary.Send(eventError, err)
+		}
+		summary.Close()
+	}()
+	originProxy, err := NewOriginProxy(c, log, metrics, summary)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	if summary != nil {
+		hookedLog := log.Hook(summary)
+		log = &hookedLog
+		go func() {
+			<-graceShutdownC
+			summary.Send(eventShuttingDown, nil)
+		}()
+	}
+	state := newTunnelState(c, log, graceShutdownC)
+	if state != nil {
+		hookedLog := log.Hook(state)
+		log = &hookedLog
+	}
+	progress := newProgressStream(c)
+	if progress != nil {
+		hookedLog := log.Hook(progress)
+		log = &hookedLog
+	}
+	watchdog := newWatchdog(c, log)
+	if watchdog != nil {
+		hookedLog := log.Hook(watchdog)
+		log = &hookedLog
+	}
+	urlProbe, err := NewURLProbe(c, log)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+	if urlProbe != nil {
+		hookedLog := log.Hook(urlProbe)
+		log = &hookedLog
+	}
+	if pidfile := c.String("pidfile"); pidfile != "" {
+		if err := writePidFile(pidfile, c.Bool("force-pidfile"), log); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+		defer removePidFile(pidfile, log)
+	}
+
+	if c.Bool("require-origin") {
+		if err := checkOriginReachable(c, log); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+	}
+
 	var config *QuickTunnelConfig
 	configFile := c.String("credentials")
+	codec, err := newCredentialsCodec(c)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
 	log.Info().Msg("Using config file: " + configFile)
 	existingTunnel := false
-	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
-		// config does not exist
-		config, err = RequestNewQuickTunnel(c, log)
-		if err != nil {
+	var delayed *delayedCallbacks
+	info, err := os.Stat(configFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		err = &ErrCredentialIO{Path: configFile, Err: err}
+		log.Error().Msg(err.Error())
+		return err
+	}
+	if err == nil && info.IsDir() {
+		err = &ErrCredentialIO{Path: configFile, Err: errors.New("is a directory, --credentials must be a file")}
+		log.Error().Msg(err.Error())
+		return err
+	}
+	if err == nil && c.Bool("fail-on-existing") {
+		err = &ErrCredentialIO{Path: configFile, Err: errors.New("already exists and --fail-on-existing is set")}
+		log.Error().Msg(err.Error())
+		return err
+	}
+	if err == nil && c.Bool("force-new") {
+		log.Info().Msg("--force-new is set, replacing the stored tunnel")
+		if err = os.Remove(configFile); err != nil {
+			err = &ErrCredentialIO{Path: configFile, Err: err}
 			log.Error().Msg(err.Error())
 			return err
 		}
-
-		log.Info().Msg("Notifying server of changed tunnel")
-		callbackOperation := func() error {
-			resp, err := http.Post(fmt.Sprintf("%s/%s", c.String("url"), c.String("callback")), "text/plain", strings.NewReader(config.URL))
+		if err = callbacks.once.Remove(); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+		err = os.ErrNotExist
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// config does not exist
+		if maxJitter := c.Duration("startup-jitter"); maxJitter > 0 {
+			jitter := time.Duration(rand.Int63n(int64(maxJitter)))
+			log.Info().Msgf("Waiting %s before requesting a new quick Tunnel", jitter)
+			select {
+			case <-time.After(jitter):
+			case <-graceShutdownC:
+				return nil
+			}
+		}
+		if c.Duration("callback-delay") > 0 && !callbacks.Empty() && !c.Bool("dry-run") {
+			config, err = createQuickTunnel(c, log, nil, summary, progress)
 			if err != nil {
+				log.Error().Msg(err.Error())
 				return err
 			}
-			if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-				return nil
-			} else {
-				return errors.New("Callback error")
+			delayed = newDelayedCallbacks(c, callbacks, config, codec, progress, log, graceShutdownC)
+			hookedLog := log.Hook(delayed)
+			log = &hookedLog
+		} else {
+			config, err = createQuickTunnel(c, log, callbacks, summary, progress)
+			if err != nil {
+				log.Error().Msg(err.Error())
+				return err
 			}
 		}
-		err := backoff.Retry(callbackOperation, backoff.NewExponentialBackOff())
+	} else {
+		config, err = ReadQuickTunnelConfig(configFile, codec)
 		if err != nil {
 			log.Error().Msg(err.Error())
 			return err
 		}
+		existingTunnel = true
+		progress.SetURL(quickTunnelURL(config.URL))
+		callbacks.token.Set(config.CallbackToken)
+		// Tunnels stored before --shorten-with was set get a link for this run
+		shortenTunnelURL(c, config, log)
+		if callbacks.once.Pending(config.URL) && !callbacks.Empty() && !c.Bool("dry-run") {
+			// The process stopped before the callbacks were notified of the stored tunnel
+			if c.Duration("callback-delay") > 0 {
+				delayed = newDelayedCallbacks(c, callbacks, config, codec, progress, log, graceShutdownC)
+				hookedLog := log.Hook(delayed)
+				log = &hookedLog
+			} else {
+				log.Info().Msg("Notifying server of stored tunnel, --callback-once-file has no record of it")
+				if err := callbacks.Notify(config); err != nil {
+					log.Error().Msg(err.Error())
+					return err
+				}
+				progress.Emit(phaseCallbackSent)
+				if config.CallbackToken != "" && !c.Bool("readonly-credentials") {
+					if err := WriteQuickTunnelConfig(configFile, codec, config); err != nil {
+						log.Err(err).Msg("Failed to store the callback token")
+					}
+				}
+			}
+		}
+	}
 
-		file, _ := json.MarshalIndent(config, "", " ")
-		err = ioutil.WriteFile(configFile, file, 0644)
+	log.Info().Msg("Using: " + config.URL)
+	if c.Bool("announce-both-schemes") {
+		log.Info().Msgf("Public URLs: %s and %s", quickTunnelURL(config.URL), quickTunnelHTTPURL(config.URL))
+	}
+	if config.ShortURL != "" {
+		log.Info().Msg("Short link: " + config.ShortURL)
+	}
+	if existingTunnel {
+		if err := appendURLHistory(c, config, false); err != nil {
+			log.Err(err).Msg("Failed to record tunnel URL")
+		}
+	}
+	summary.SetURL(quickTunnelURL(config.URL))
+	metrics.SetGauge(metricURLInfo, 1, map[string]string{"url": quickTunnelURL(config.URL)})
+	if err := reportDetachedURL(quickTunnelURL(config.URL)); err != nil {
+		log.Err(err).Msg("Failed to report tunnel URL to the waiting process")
+	}
+	if c.Bool("dry-run") {
+		log.Info().Msg("--dry-run is set, not connecting the tunnel")
+		return nil
+	}
+
+	if (c.Bool("check-udp") || c.Bool("fail-fast-on-udp-block")) && c.String("protocol") == connection.QUIC.String() {
+		if err := checkUDP(c, log); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+	}
+
+	if originProxy != nil {
+		proxyURL, err := originProxy.Start()
 		if err != nil {
 			log.Error().Msg(err.Error())
 			return err
 		}
-	} else {
-		byteValue, _ := ioutil.ReadFile(configFile)
-		json.Unmarshal(byteValue, &config)
-		existingTunnel = true
+		defer originProxy.Close()
+		c.Set("url", proxyURL)
 	}
 
-	log.Info().Msg("Using: " + config.URL)
+	rotator := &tunnelRotator{
+		c:              c,
+		log:            log,
+		callbacks:      callbacks,
+		summary:        summary,
+		metrics:        metrics,
+		graceShutdownC: graceShutdownC,
+		url:            quickTunnelURL(config.URL),
+	}
+	handleRotateSignal(rotator)
+	if c.Bool("stdin-control") {
+		if err := startStdinControl(quickTunnelURL(config.URL), rotator, graceShutdownC, log); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+	}
 
-	if !c.IsSet("protocol") {
-		c.Set("protocol", "quic")
+	if err := state.Start(quickTunnelURL(config.URL)); err != nil {
+		log.Error().Msg(err.Error())
+		return err
 	}
+	defer state.Close()
 
-	err := tunnel.StartServer(
+	urlProbe.Start(quickTunnelURL(config.URL), graceShutdownC)
+
+	progress.Emit(phaseConnectingEdge)
+	watchdog.Start(graceShutdownC)
+	defer watchdog.Stop()
+	shutdownCallbacks.Start(quickTunnelURL(config.URL))
+
+	err = tunnel.StartServer(
 		c,
 		version,
 		&connection.NamedTunnelConfig{Credentials: config.Credentials, QuickTunnelUrl: config.URL},
 		log,
 		false,
 	)
-	if err == nil || !existingTunnel {
+	shutdownCallbacks.Wait()
+	if err := delayed.Err(); err != nil {
+		// Already logged when the callbacks failed
 		return err
 	}
+	if rotator.Rotated() {
+		// Exit non-zero so the supervisor restarts with the new credentials
+		rotatedErr := errors.New("tunnel URL was rotated, restart to connect with the new credentials")
+		log.Error().Msg(rotatedErr.Error())
+		return rotatedErr
+	}
+	if err == nil && urlProbe.Failed() {
+		probeErr := errors.New("public tunnel URL stopped responding")
+		log.Error().Msg(probeErr.Error())
+		return probeErr
+	}
+	if err == nil {
+		return nil
+	}
+	if !existingTunnel {
+		edgeErr := &ErrEdgeUnreachable{Err: err}
+		log.Error().Msg(edgeErr.Error())
+		return edgeErr
+	}
+	if c.Bool("readonly-credentials") {
+		edgeErr := &ErrEdgeUnreachable{Err: errors.Wrapf(err, "Failed to start server. The tunnel in %s may no longer exist, but --readonly-credentials keeps it from being replaced", configFile)}
+		log.Error().Msg(edgeErr.Error())
+		return edgeErr
+	}
 	// Delete existing config and try again
 	deleteErr := os.Remove(configFile)
 	if deleteErr != nil {
+		deleteErr = &ErrCredentialIO{Path: configFile, Err: deleteErr}
 		log.Error().Msg(deleteErr.Error())
 		return deleteErr
 	}
+	if err := callbacks.once.Remove(); err != nil {
+		log.Err(err).Msg("Failed to remove the record of the deleted tunnel")
+	}
 
 	// The following doesn't work because of prometheus duplicate metrics collector registration attempted
 	// For now let's just return an error and have the process restarted by systemd or the like
-	//return RunPersistentQuickTunnel(c, log, version)
-	return errors.New("Failed to start server. Restart to create new tunnel.")
+	//return RunPersistentQuickTunnel(c, log, version, graceShutdownC)
+	edgeErr := &ErrEdgeUnreachable{Err: errors.Wrap(err, "Failed to start server. Restart to create new tunnel")}
+	log.Error().Msg(edgeErr.Error())
+	return edgeErr
+}
+
+// createQuickTunnel requests a new quick tunnel, notifies the callbacks of its URL and stores its credentials.
+// With nil callbacks they are notified later, by delayedCallbacks.
+func createQuickTunnel(c *cli.Context, log *zerolog.Logger, callbacks *CallbackGroup, summary *SummaryWebhook, progress *progressStream) (*QuickTunnelConfig, error) {
+	progress.Emit(phaseRequestingTunnel)
+	config, err := RequestNewQuickTunnel(c, log)
+	if err != nil {
+		return nil, err
+	}
+	progress.SetURL(quickTunnelURL(config.URL))
+	progress.Emit(phaseTunnelCreated)
+	shortenTunnelURL(c, config, log)
+	summary.SetURL(quickTunnelURL(config.URL))
+	summary.Send(eventURLChanged, nil)
+
+	if callbacks == nil {
+		log.Info().Msgf("Notifying server of changed tunnel %s after it connects", c.Duration("callback-delay"))
+	} else if callbacks.Empty() {
+		log.Info().Msg("No --callback set, not notifying of changed tunnel")
+	} else {
+		log.Info().Msg("Notifying server of changed tunnel")
+		if err := callbacks.Notify(config); err != nil {
+			return nil, err
+		}
+		progress.Emit(phaseCallbackSent)
+	}
+
+	if c.Bool("readonly-credentials") {
+		log.Warn().Msg("--readonly-credentials is set, the new tunnel is kept in memory only and won't survive a restart")
+	} else {
+		if err := writeCredentials(c, config); err != nil {
+			return nil, err
+		}
+		progress.Emit(phaseCredentialsWritten)
+	}
+	if err := appendURLHistory(c, config, true); err != nil {
+		log.Err(err).Msg("Failed to record tunnel URL")
+	}
+	return config, nil
+}
+
+// writeCredentials stores the tunnel in the --credentials file as the credentials options say.
+func writeCredentials(c *cli.Context, config *QuickTunnelConfig) error {
+	codec, err := newCredentialsCodec(c)
+	if err != nil {
+		return err
+	}
+	return WriteQuickTunnelConfig(c.String("credentials"), codec, config)
 }
 
 func RequestNewQuickTunnel(c *cli.Context, log *zerolog.Logger) (*QuickTunnelConfig, error) {
@@ -107,21 +410,39 @@ func RequestNewQuickTunnel(c *cli.Context, log *zerolog.Logger) (*QuickTunnelCon
 
 	client := http.Client{
 		Transport: &http.Transport{
+			Proxy:                 outboundProxy(c),
+			DialContext:           outboundDialer(c).DialContext,
 			TLSHandshakeTimeout:   httpTimeout,
 			ResponseHeaderTimeout: httpTimeout,
 		},
 		Timeout: httpTimeout,
 	}
 
-	resp, err := client.Post(fmt.Sprintf("%s/tunnel", c.String("quick-service")), "application/json", nil)
+	responseMap, err := parseQuickServiceResponseMap(c.StringSlice("response-map"))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to request quick Tunnel")
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var data QuickTunnelResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal quick Tunnel")
+	// The same key on every retry, so a quick-service that honors it returns the tunnel it already created
+	// for a request whose response was lost, instead of creating another
+	idempotencyKey := uuid.New().String()
+	var data *QuickTunnelResponse
+	requestOperation := func() error {
+		var err error
+		data, err = postQuickTunnelRequest(&client, c.String("quick-service"), idempotencyKey, responseMap, log)
+		if err != nil && !retryableQuickServiceError(err) {
+			if c.Int("request-retries") > 0 {
+				log.Warn().Msg("The quick-service refused the request, not retrying")
+			}
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	retryPolicy := backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(c.Int("request-retries")))
+	err = backoff.RetryNotify(requestOperation, retryPolicy, func(err error, next time.Duration) {
+		log.Warn().Msgf("%s, retrying in %s", err, next.Round(time.Millisecond))
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	tunnelID, err := uuid.Parse(data.Result.ID)
@@ -136,24 +457,145 @@ func RequestNewQuickTunnel(c *cli.Context, log *zerolog.Logger) (*QuickTunnelCon
 		TunnelName:   data.Result.Name,
 	}
 
-	url := data.Result.Hostname
-	if !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
-	}
+	url := quickTunnelURL(data.Result.Hostname)
 
-	for _, line := range AsciiBox([]string{
+	boxLines := []string{
 		"Your quick Tunnel has been created! Visit it at (it may take some time to be reachable):",
 		url,
-	}, 2) {
+	}
+	box := AsciiBox(boxLines, 2)
+	if colorTerminal(c) {
+		box = AsciiBoxColored(boxLines, 2, 1)
+	}
+	for _, line := range box {
 		log.Info().Msg(line)
 	}
 
 	return &QuickTunnelConfig{URL: data.Result.Hostname, Credentials: credentials}, nil
 }
 
+// postQuickTunnelRequest asks the quick-service for a new tunnel once. A non-empty idempotencyKey is sent as
+// the Idempotency-Key header. With a responseMap, the tunnel is read from the fields it points to instead of
+// trycloudflare's.
+func postQuickTunnelRequest(client *http.Client, quickService, idempotencyKey string, responseMap quickServiceResponseMap, log *zerolog.Logger) (*QuickTunnelResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/tunnel", quickService), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request quick Tunnel")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request quick Tunnel")
+	}
+	defer resp.Body.Close()
+	warnClockSkew(resp, time.Now(), log)
+	headers := quickServiceHeaders(resp.Header)
+	if headers != "" {
+		log.Debug().Msgf("quick-service responded with status %d: %s", resp.StatusCode, headers)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxQuickServiceResponse))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read quick Tunnel response")
+	}
+	var data QuickTunnelResponse
+	decodeErr := json.Unmarshal(body, &data)
+	if responseMap != nil {
+		// Errors and success are still read where trycloudflare has them, if they are there at all
+		data.Result, decodeErr = responseMap.decode(body)
+	}
+	if decodeErr != nil && !isJSONContentType(resp.Header.Get("Content-Type")) {
+		return nil, &ErrQuickServiceRejected{
+			StatusCode:  resp.StatusCode,
+			ContentType: dashIfEmpty(resp.Header.Get("Content-Type")),
+			Body:        responseSnippet(body),
+			Headers:     headers,
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || len(data.Errors) > 0 {
+		return nil, &ErrQuickServiceRejected{StatusCode: resp.StatusCode, Errors: data.Errors, Headers: headers}
+	}
+	if decodeErr != nil {
+		return nil, errors.Wrapf(decodeErr, "failed to unmarshal quick Tunnel: %s", responseSnippet(body))
+	}
+	return &data, nil
+}
+
+// retryableQuickServiceError tells failures that can clear up by themselves, such as network errors, 5xx
+// and 429 responses, from a quick-service refusing the request, where retrying would only fail again.
+func retryableQuickServiceError(err error) bool {
+	var rejected *ErrQuickServiceRejected
+	if !errors.As(err, &rejected) {
+		return true
+	}
+	return rejected.StatusCode == http.StatusTooManyRequests || rejected.StatusCode >= 500
+}
+
+// quickServiceHeaders picks the headers Cloudflare support needs to look into a failed request, the ray ID
+// and any rate limit information, and formats them for logs and errors.
+func quickServiceHeaders(header http.Header) string {
+	var names []string
+	for name := range header {
+		lower := strings.ToLower(name)
+		if lower == "cf-ray" || lower == "retry-after" || strings.HasPrefix(lower, "ratelimit") || strings.HasPrefix(lower, "x-ratelimit") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = fmt.Sprintf("%s=%s", name, strings.Join(header[name], ","))
+	}
+	return strings.Join(fields, " ")
+}
+
+// Responses from the quick-service are small, anything bigger is not a tunnel.
+const maxQuickServiceResponse = 1 << 20
+
+// Length of the response body included in quick-service errors.
+const maxResponseSnippet = 200
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// responseSnippet is the start of a response body on a single line, for error messages.
+func responseSnippet(body []byte) string {
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if len(snippet) > maxResponseSnippet {
+		snippet = snippet[:maxResponseSnippet] + "..."
+	}
+	return fmt.Sprintf("%q", snippet)
+}
+
+// quickTunnelURL is the public URL of the tunnel with the given hostname. A scheme returned by the quick-service
+// is kept as is, otherwise https is assumed. url.Parse isn't used because it reads "host:port" as a scheme.
+func quickTunnelURL(hostname string) string {
+	if strings.Contains(hostname, "://") {
+		return hostname
+	}
+	return "https://" + hostname
+}
+
+// quickTunnelHTTPURL is the plain http form of the tunnel URL, announced next to it with --announce-both-schemes.
+func quickTunnelHTTPURL(hostname string) string {
+	url := quickTunnelURL(hostname)
+	if strings.HasPrefix(url, "https://") {
+		return "http://" + strings.TrimPrefix(url, "https://")
+	}
+	return url
+}
+
 type QuickTunnelConfig struct {
-	URL         string
-	Credentials connection.Credentials
+	URL           string
+	Credentials   connection.Credentials
+	CallbackToken string `json:",omitempty"`
+	// Short link from --shorten-with
+	ShortURL string `json:",omitempty"`
 }
 
 type QuickTunnelResponse struct {
@@ -182,18 +624,41 @@ func AsciiBox(lines []string, padding int) (box []string) {
 	border := "+" + strings.Repeat("-", maxLen+(padding*2)) + "+"
 	box = append(box, border)
 	for _, line := range lines {
-		box = append(box, "|"+spacer+line+strings.Repeat(" ", maxLen-len(line))+spacer+"|")
+		box = append(box, "|"+spacer+line+strings.Repeat(" ", maxLen-visibleLen(line))+spacer+"|")
 	}
 	box = append(box, border)
 	return
 }
 
+// AsciiBoxColored is AsciiBox with the line at index highlight rendered in bold cyan. The border stays plain.
+func AsciiBoxColored(lines []string, padding int, highlight int) []string {
+	colored := make([]string, len(lines))
+	copy(colored, lines)
+	if highlight >= 0 && highlight < len(colored) {
+		colored[highlight] = ansiBoldCyan + colored[highlight] + ansiReset
+	}
+	return AsciiBox(colored, padding)
+}
+
+// colorTerminal reports whether log lines only go to an interactive terminal, so ANSI colors are safe to use.
+func colorTerminal(c *cli.Context) bool {
+	if c.String(logger.LogFileFlag) != "" || c.String(logger.LogDirectoryFlag) != "" || c.Bool("log-syslog") {
+		return false
+	}
+	return term.IsTerminal(int(os.Stderr.Fd()))
+}
+
 func maxLen(lines []string) int {
 	max := 0
 	for _, line := range lines {
-		if len(line) > max {
-			max = len(line)
+		if visibleLen(line) > max {
+			max = visibleLen(line)
 		}
 	}
 	return max
 }
+
+// visibleLen is the length of line once ANSI escape sequences are removed.
+func visibleLen(line string) int {
+	return len(ansiEscape.ReplaceAllString(line, ""))
+}
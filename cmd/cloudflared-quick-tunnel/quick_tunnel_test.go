@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/schmidek/cloudflare-quick-tunnel/cmd/cloudflared-quick-tunnel/internal/quicktest"
+)
+
+// A quick-service that honors Idempotency-Key: the first response for a key is lost, and the retry gets
+// the tunnel created for it.
+func TestRequestNewQuickTunnelIdempotencyKey(t *testing.T) {
+	var lock sync.Mutex
+	created := make(map[string]string)
+	var keys []string
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		key := r.Header.Get("Idempotency-Key")
+		keys = append(keys, key)
+		id, ok := created[key]
+		if !ok {
+			id = "b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d"
+			created[key] = id
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuickTunnelResponse{
+			Success: true,
+			Result:  QuickTunnel{ID: id, Hostname: "a.trycloudflare.com", AccountTag: "acc", Secret: []byte("secret")},
+		})
+	}))
+	defer service.Close()
+
+	config, err := RequestNewQuickTunnel(runContext(t, "--quick-service", service.URL, "--request-retries", "2"), testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != "a.trycloudflare.com" {
+		t.Errorf("got tunnel %s", config.URL)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("retry sent Idempotency-Key %q, want the same key twice", keys)
+	}
+	if len(created) != 1 {
+		t.Errorf("the quick-service created %d tunnels, want 1", len(created))
+	}
+
+	if _, err := RequestNewQuickTunnel(runContext(t, "--quick-service", service.URL, "--request-retries", "2"), testLog()); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 {
+		t.Errorf("a new run reused the Idempotency-Key of the previous one")
+	}
+}
+
+// The whole run flow up to connecting to the edge, against a fake quick-service, callback receiver and origin.
+func TestRunPersistentQuickTunnelDryRun(t *testing.T) {
+	service := quicktest.NewQuickService(t, "dry-run.trycloudflare.com")
+	receiver := quicktest.NewCallbackReceiver(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	args := []string{
+		"--quick-service", service.URL,
+		"--callback", receiver.URL + "/callback",
+		"--url", origin.URL,
+		"--credentials", credentials,
+		"--require-origin",
+		"--dry-run",
+	}
+
+	run := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		log := zerolog.New(&out)
+		if err := RunPersistentQuickTunnel(runContext(t, args...), &log, "test", make(chan struct{})); err != nil {
+			t.Fatalf("run failed: %v\n%s", err, out.String())
+		}
+		return out.String()
+	}
+
+	logs := run()
+	if !strings.Contains(logs, "Using: dry-run.trycloudflare.com") {
+		t.Errorf("the tunnel URL wasn't logged:\n%s", logs)
+	}
+	if bodies := receiver.Bodies(); !reflect.DeepEqual(bodies, []string{"dry-run.trycloudflare.com"}) {
+		t.Errorf("callback received %q, want the hostname once", bodies)
+	}
+	codec, err := newCredentialsCodec(runContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadQuickTunnelConfig(credentials, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != service.Tunnel.Hostname || config.Credentials.AccountTag != service.Tunnel.AccountTag ||
+		config.Credentials.TunnelID.String() != service.Tunnel.ID || !bytes.Equal(config.Credentials.TunnelSecret, service.Tunnel.Secret) {
+		t.Errorf("stored credentials %+v don't match the tunnel %+v", config, service.Tunnel)
+	}
+
+	// A restart reuses the stored tunnel without asking for another or notifying again
+	logs = run()
+	if !strings.Contains(logs, "Using: dry-run.trycloudflare.com") {
+		t.Errorf("the stored tunnel URL wasn't logged:\n%s", logs)
+	}
+	if service.Requests() != 1 || len(receiver.Bodies()) != 1 {
+		t.Errorf("restart made %d quick-service requests and %d callbacks, want 1 of each in total", service.Requests(), len(receiver.Bodies()))
+	}
+}
+
+func TestQuickTunnelURL(t *testing.T) {
+	tests := []struct {
+		hostname    string
+		wantURL     string
+		wantHTTPURL string
+	}{
+		{hostname: "a.trycloudflare.com", wantURL: "https://a.trycloudflare.com", wantHTTPURL: "http://a.trycloudflare.com"},
+		{hostname: "https://a.trycloudflare.com", wantURL: "https://a.trycloudflare.com", wantHTTPURL: "http://a.trycloudflare.com"},
+		{hostname: "http://quick.internal", wantURL: "http://quick.internal", wantHTTPURL: "http://quick.internal"},
+		{hostname: "quick.internal:8443", wantURL: "https://quick.internal:8443", wantHTTPURL: "http://quick.internal:8443"},
+	}
+	for _, test := range tests {
+		if got := quickTunnelURL(test.hostname); got != test.wantURL {
+			t.Errorf("quickTunnelURL(%q) = %q, want %q", test.hostname, got, test.wantURL)
+		}
+		if got := quickTunnelHTTPURL(test.hostname); got != test.wantHTTPURL {
+			t.Errorf("quickTunnelHTTPURL(%q) = %q, want %q", test.hostname, got, test.wantHTTPURL)
+		}
+	}
+}
+
+func TestRunPersistentQuickTunnelCredentialsFile(t *testing.T) {
+	service := quicktest.NewQuickService(t, "new.trycloudflare.com")
+	tests := []struct {
+		name     string
+		setup    func(path string) error
+		wantErr  bool
+		wantLogs string
+		// File permissions don't stop root, or anyone on Windows, from reading the file
+		needsPermissions bool
+	}{
+		{name: "missing file creates a tunnel", setup: func(path string) error { return nil }, wantLogs: "Using: new.trycloudflare.com"},
+		{name: "stored tunnel", setup: func(path string) error {
+			return ioutil.WriteFile(path, []byte(`{"URL":"stored.trycloudflare.com"}`), 0600)
+		}, wantLogs: "Using: stored.trycloudflare.com"},
+		{name: "directory", setup: func(path string) error { return os.Mkdir(path, 0700) }, wantErr: true, wantLogs: "is a directory"},
+		{name: "corrupt file", setup: func(path string) error { return ioutil.WriteFile(path, []byte("{not json"), 0600) }, wantErr: true, wantLogs: "invalid credentials"},
+		{name: "no URL stored", setup: func(path string) error { return ioutil.WriteFile(path, []byte("{}"), 0600) }, wantErr: true, wantLogs: "no tunnel URL stored"},
+		{name: "permission denied", setup: func(path string) error {
+			return ioutil.WriteFile(path, []byte(`{"URL":"stored.trycloudflare.com"}`), 0000)
+		}, wantErr: true, wantLogs: "permission denied", needsPermissions: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.needsPermissions && (runtime.GOOS == "windows" || os.Geteuid() == 0) {
+				t.Skip("file permissions aren't enforced")
+			}
+			credentials := filepath.Join(t.TempDir(), "credentials.json")
+			if err := test.setup(credentials); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			log := zerolog.New(&out)
+			c := runContext(t, "--quick-service", service.URL, "--credentials", credentials, "--dry-run")
+			err := RunPersistentQuickTunnel(c, &log, "test", make(chan struct{}))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			var credentialErr *ErrCredentialIO
+			if err != nil && !errors.As(err, &credentialErr) {
+				t.Fatalf("got %T, want an ErrCredentialIO", err)
+			}
+			if !strings.Contains(out.String(), test.wantLogs) {
+				t.Fatalf("logs don't contain %q:\n%s", test.wantLogs, out.String())
+			}
+		})
+	}
+}
+
+func TestPostQuickTunnelRequestResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantErr     string
+	}{
+		{name: "tunnel", status: http.StatusOK, contentType: "application/json", body: `{"success":true,"result":{"id":"b7d7f3e6-52a4-4f4a-9d0e-6c1d6c1d6c1d","hostname":"a.trycloudflare.com","account_tag":"acc","secret":"c2VjcmV0"}}`},
+		{name: "HTML error page", status: http.StatusBadGateway, contentType: "text/html", body: "<html>\n<body>Bad gateway</body>\n</html>", wantErr: `quick-service responded with text/html instead of JSON, status 502: "<html> <body>Bad gateway</body> </html>"`},
+		{name: "no content type", status: http.StatusServiceUnavailable, body: "unavailable", wantErr: "responded with - instead of JSON, status 503"},
+		{name: "JSON errors", status: http.StatusTooManyRequests, contentType: "application/json", body: `{"success":false,"errors":[{"code":1015,"message":"rate limited"}]}`, wantErr: "status 429"},
+		{name: "malformed JSON", status: http.StatusOK, contentType: "application/json; charset=utf-8", body: `{"success":tru`, wantErr: `failed to unmarshal quick Tunnel: "{\"success\":tru"`},
+		{name: "long body", status: http.StatusBadGateway, contentType: "text/plain", body: strings.Repeat("x", 300), wantErr: strings.Repeat("x", maxResponseSnippet) + `..."`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.contentType != "" {
+					w.Header().Set("Content-Type", test.contentType)
+				} else {
+					w.Header()["Content-Type"] = nil
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer service.Close()
+			_, err := postQuickTunnelRequest(service.Client(), service.URL, "", nil, testLog())
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want it to contain %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestNewQuickTunnelRetries(t *testing.T) {
+	tests := []struct {
+		status       int
+		wantRequests int
+	}{
+		{status: http.StatusBadRequest, wantRequests: 1},
+		{status: http.StatusUnauthorized, wantRequests: 1},
+		{status: http.StatusForbidden, wantRequests: 1},
+		{status: http.StatusNotFound, wantRequests: 1},
+		{status: http.StatusTooManyRequests, wantRequests: 3},
+		{status: http.StatusInternalServerError, wantRequests: 3},
+		{status: http.StatusBadGateway, wantRequests: 3},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			// Retries back off for about a second each
+			t.Parallel()
+			var lock sync.Mutex
+			requests := 0
+			service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				requests++
+				lock.Unlock()
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				w.Write([]byte(`{"success":false,"errors":[{"code":1,"message":"refused"}]}`))
+			}))
+			defer service.Close()
+			_, err := RequestNewQuickTunnel(runContext(t, "--quick-service", service.URL, "--request-retries", "2"), testLog())
+			if err == nil {
+				t.Fatal("expected the request to fail")
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if requests != test.wantRequests {
+				t.Fatalf("the quick-service got %d requests, want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryableQuickServiceError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection refused"), want: true},
+		{name: "rate limited", err: &ErrQuickServiceRejected{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: &ErrQuickServiceRejected{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "wrapped server error", err: errors.Wrap(&ErrQuickServiceRejected{StatusCode: http.StatusBadGateway}, "request"), want: true},
+		{name: "bad request", err: &ErrQuickServiceRejected{StatusCode: http.StatusBadRequest}},
+		{name: "forbidden", err: &ErrQuickServiceRejected{StatusCode: http.StatusForbidden}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryableQuickServiceError(test.err); got != test.want {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Now()
+	type request struct {
+		key      string
+		after    time.Duration
+		wantOK   bool
+		wantWait time.Duration
+	}
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		perIP    bool
+		requests []request
+	}{
+		{name: "burst then limited", rate: 2, burst: 3, requests: []request{
+			{wantOK: true}, {wantOK: true}, {wantOK: true},
+			{wantOK: false, wantWait: 500 * time.Millisecond},
+		}},
+		{name: "refills at the rate", rate: 2, burst: 1, requests: []request{
+			{wantOK: true},
+			{after: 100 * time.Millisecond, wantOK: false, wantWait: 400 * time.Millisecond},
+			{after: 500 * time.Millisecond, wantOK: true},
+		}},
+		{name: "refill stops at the burst", rate: 10, burst: 2, requests: []request{
+			{after: time.Hour, wantOK: true}, {after: time.Hour, wantOK: true},
+			{after: time.Hour, wantOK: false, wantWait: 100 * time.Millisecond},
+		}},
+		{name: "default burst is one second", rate: 2, requests: []request{
+			{wantOK: true}, {wantOK: true}, {wantOK: false, wantWait: 500 * time.Millisecond},
+		}},
+		{name: "per IP buckets", rate: 1, burst: 1, perIP: true, requests: []request{
+			{key: "a", wantOK: true}, {key: "a", wantOK: false, wantWait: time.Second}, {key: "b", wantOK: true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := newRateLimiter(test.rate, test.burst, test.perIP)
+			for i, request := range test.requests {
+				ok, wait := limiter.allow(request.key, start.Add(request.after))
+				if ok != request.wantOK || wait != request.wantWait {
+					t.Fatalf("request %d: got %v, wait %s, want %v, wait %s", i, ok, wait, request.wantOK, request.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := newRateLimiter(1, 1, true)
+	start := limiter.lastSweep
+	limiter.allow("a", start)
+	limiter.allow("b", start)
+	limiter.allow("c", start.Add(rateLimitSweepInterval))
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("got %d buckets after the sweep, want only the new one", len(limiter.buckets))
+	}
+}
+
+func TestOriginProxyRateLimit(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	proxy, err := NewOriginProxy(runContext(t, "--url", origin.URL, "--rate-limit", "1", "--rate-limit-per-ip"), testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	tests := []struct {
+		client         string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{client: "192.0.2.1", wantStatus: http.StatusOK},
+		{client: "192.0.2.1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1"},
+		{client: "192.0.2.2", wantStatus: http.StatusOK},
+	}
+	for i, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, proxyURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("CF-Connecting-IP", test.client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.wantStatus || resp.Header.Get("Retry-After") != test.wantRetryAfter {
+			t.Fatalf("request %d: got %d with Retry-After %q, want %d with %q", i, resp.StatusCode, resp.Header.Get("Retry-After"), test.wantStatus, test.wantRetryAfter)
+		}
+	}
+}
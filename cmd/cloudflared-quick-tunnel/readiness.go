@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// States of the tunnel reported by /readyz and --state-file.
+const (
+	stateStarting     = "starting"
+	stateConnected    = "connected"
+	stateReconnecting = "reconnecting"
+	stateDraining     = "draining"
+	stateStopped      = "stopped"
+)
+
+type TunnelStateInfo struct {
+	State string `json:"state"`
+	URL   string `json:"url,omitempty"`
+	// Only set with --announce-both-schemes
+	HTTPURL string    `json:"http_url,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// tunnelState tracks whether the tunnel should be sent traffic, for orchestrators doing rolling restarts.
+// It is ready once cloudflared registers its first connection, stops being ready while every connection has
+// dropped and cloudflared is reconnecting, and for good as soon as a graceful shutdown starts, while cloudflared
+// drains requests over --grace-period. Each change is served on
+// /readyz of --readiness-address and written to --state-file. Its methods do nothing on a nil tunnelState.
+type tunnelState struct {
+	address        string
+	stateFile      string
+	bothSchemes    bool
+	log            *zerolog.Logger
+	graceShutdownC chan struct{}
+	server         *http.Server
+
+	lock sync.Mutex
+	info TunnelStateInfo
+	// Indexes of the registered edge connections
+	connections map[int]bool
+}
+
+// newTunnelState returns nil unless --readiness-address or --state-file is set.
+func newTunnelState(c *cli.Context, log *zerolog.Logger, graceShutdownC chan struct{}) *tunnelState {
+	if c.String("readiness-address") == "" && c.String("state-file") == "" {
+		return nil
+	}
+	return &tunnelState{
+		address:        c.String("readiness-address"),
+		stateFile:      c.String("state-file"),
+		bothSchemes:    c.Bool("announce-both-schemes"),
+		log:            log,
+		graceShutdownC: graceShutdownC,
+		info:           TunnelStateInfo{State: stateStarting, Since: time.Now().UTC()},
+		connections:    make(map[int]bool),
+	}
+}
+
+// Start serves /readyz, writes the starting state and marks the tunnel draining once the graceful shutdown starts.
+func (s *tunnelState) Start(url string) error {
+	if s == nil {
+		return nil
+	}
+	if s.address != "" {
+		listener, err := net.Listen("tcp", s.address)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen on --readiness-address")
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/readyz", s.serveReadyz)
+		s.server = &http.Server{Handler: mux}
+		go func() {
+			if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				s.log.Err(err).Msg("Readiness endpoint stopped")
+			}
+		}()
+		s.log.Info().Msgf("Serving tunnel readiness on http://%s/readyz", listener.Addr())
+	}
+	s.lock.Lock()
+	s.info.URL = url
+	if s.bothSchemes {
+		s.info.HTTPURL = quickTunnelHTTPURL(url)
+	}
+	s.write()
+	s.lock.Unlock()
+	go func() {
+		<-s.graceShutdownC
+		s.set(stateDraining)
+	}()
+	return nil
+}
+
+// Run is a zerolog hook that marks the tunnel connected while cloudflared has a registered connection, and
+// reconnecting once the last one drops.
+func (s *tunnelState) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	registered := connectionRegistered.MatchString(msg)
+	if !registered && !connectionDropped.MatchString(msg) {
+		return
+	}
+	index, ok := logConnIndex(e)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if registered {
+		if ok {
+			s.connections[index] = true
+		}
+		s.moveTo(stateConnected)
+		return
+	}
+	if !ok || !s.connections[index] {
+		return
+	}
+	delete(s.connections, index)
+	if len(s.connections) == 0 {
+		s.moveTo(stateReconnecting)
+	}
+}
+
+func (s *tunnelState) set(state string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.moveTo(state)
+}
+
+// moveTo moves to state. Once draining, the tunnel only moves on to stopped, even if cloudflared reconnects.
+func (s *tunnelState) moveTo(state string) {
+	current := s.info.State
+	if current == state || current == stateStopped || current == stateDraining && state != stateStopped {
+		return
+	}
+	s.log.Debug().Msgf("Tunnel state changed from %s to %s", current, state)
+	s.info.State = state
+	s.info.Since = time.Now().UTC()
+	s.write()
+}
+
+func (s *tunnelState) write() {
+	if s.stateFile == "" {
+		return
+	}
+	file, _ := json.MarshalIndent(s.info, "", " ")
+	if err := writeFileAtomic(s.stateFile, file); err != nil {
+		s.log.Err(err).Msg("Failed to write --state-file")
+	}
+}
+
+func (s *tunnelState) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	info := s.info
+	s.lock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if info.State != stateConnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+// Close records the stopped state and stops serving /readyz.
+func (s *tunnelState) Close() {
+	if s == nil {
+		return
+	}
+	s.set(stateStopped)
+	if s.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+		defer cancel()
+		s.server.Shutdown(ctx)
+	}
+}
+
+// writeFileAtomic replaces path through a rename, so readers never see a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
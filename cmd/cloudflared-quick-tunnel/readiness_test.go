@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestTunnelStateFollowsConnections(t *testing.T) {
+	type event struct {
+		msg   string
+		index int
+	}
+	tests := []struct {
+		name      string
+		events    []event
+		wantState string
+	}{
+		{name: "starting", wantState: stateStarting},
+		{name: "registered", events: []event{{"Connection 1a registered", 0}}, wantState: stateConnected},
+		{name: "dropped before registering", events: []event{{"Connection terminated", 0}}, wantState: stateStarting},
+		{name: "one of two dropped", events: []event{
+			{"Connection 1a registered", 0}, {"Connection 2b registered", 1}, {"Connection terminated", 0},
+		}, wantState: stateConnected},
+		{name: "all dropped", events: []event{
+			{"Connection 1a registered", 0}, {"Connection 2b registered", 1}, {"Connection terminated", 0}, {"Unregistered tunnel connection", 1},
+		}, wantState: stateReconnecting},
+		{name: "re-registered", events: []event{
+			{"Connection 1a registered", 0}, {"Connection terminated", 0}, {"Connection 3c registered", 0},
+		}, wantState: stateConnected},
+		{name: "another connection failing to connect", events: []event{
+			{"Connection 1a registered", 0}, {"Connection terminated", 1},
+		}, wantState: stateConnected},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := &tunnelState{log: testLog(), info: TunnelStateInfo{State: stateStarting}, connections: make(map[int]bool)}
+			for _, e := range test.events {
+				logConnection(state, zerolog.InfoLevel, e.msg, e.index)
+			}
+			recorder := httptest.NewRecorder()
+			state.serveReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if state.info.State != test.wantState {
+				t.Fatalf("state %s, want %s", state.info.State, test.wantState)
+			}
+			if ready := recorder.Code == http.StatusOK; ready != (test.wantState == stateConnected) {
+				t.Errorf("/readyz answered %d in state %s", recorder.Code, state.info.State)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errRequestBodyTooLarge = errors.New("request body too large")
+
+// limitRequestBody rejects requests with a body larger than limit with 413. Bodies are checked as they are
+// streamed to the origin, and a body that turns out too large fails with errRequestBodyTooLarge, which the
+// error handler and the circuit breaker tell apart from origin failures.
+func limitRequestBody(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &maxBodyReader{ReadCloser: r.Body, remaining: limit}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBodyReader is http.MaxBytesReader with an error that can be matched.
+type maxBodyReader struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (r *maxBodyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) <= r.remaining {
+		r.remaining -= int64(n)
+		r.err = err
+		return n, err
+	}
+	n = int(r.remaining)
+	r.remaining = 0
+	r.err = errRequestBodyTooLarge
+	return n, r.err
+}
+
+// timeoutRequest bounds the whole exchange with the origin, including reading its response body. The reverse
+// proxy's error handler answers 504 if the origin hasn't responded by then.
+func timeoutRequest(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxRequestBody(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}))
+	defer origin.Close()
+	// A body that turns out too large must not count as an origin failure
+	proxy, err := NewOriginProxy(runContext(t, "--url", origin.URL, "--max-request-body", "10", "--origin-breaker-threshold", "1"), testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "small", body: "small", wantStatus: http.StatusOK},
+		{name: "at the limit", body: "0123456789", wantStatus: http.StatusOK},
+		{name: "too large", body: "this is too large", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "too large without a length", body: "this is too large", chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "small without a length", body: "small", chunked: true, wantStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(test.body)
+			if test.chunked {
+				// Hides the length from http.NewRequest, so the body is sent chunked
+				body = ioutil.NopCloser(body)
+			}
+			req, err := http.NewRequest(http.MethodPost, proxyURL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOriginRequestTimeout(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		case "/slow-body":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(500 * time.Millisecond)
+			w.Write([]byte("late"))
+		}
+	}))
+	defer origin.Close()
+	proxy, err := NewOriginProxy(runContext(t, "--url", origin.URL, "--origin-request-timeout", "100ms"), testLog(), nopMetrics{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/fast", wantStatus: http.StatusOK},
+		{path: "/slow", wantStatus: http.StatusGatewayTimeout},
+		// The status is already sent, but the body is cut off at the timeout
+		{path: "/slow-body", wantStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			start := time.Now()
+			resp, err := http.Get(proxyURL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus || string(body) != test.wantBody {
+				t.Fatalf("got %d %q, want %d %q", resp.StatusCode, body, test.wantStatus, test.wantBody)
+			}
+			if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+				t.Fatalf("the request took %s despite the timeout", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/net/http/httpguts"
+)
+
+// responseHeaders removes the --strip-response-header headers from origin responses, then adds the
+// --add-response-header ones, so a header can be replaced by stripping and adding it.
+type responseHeaders struct {
+	strip []string
+	add   http.Header
+}
+
+// newResponseHeaders returns nil if neither flag is set.
+func newResponseHeaders(c *cli.Context) (*responseHeaders, error) {
+	strip := c.StringSlice("strip-response-header")
+	add, err := parseHeaderFlag("add-response-header", c.StringSlice("add-response-header"))
+	if err != nil {
+		return nil, err
+	}
+	if len(strip) == 0 && len(add) == 0 {
+		return nil, nil
+	}
+	h := &responseHeaders{add: add}
+	for _, name := range strip {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, errors.Errorf("invalid --strip-response-header %q, not a valid header name", name)
+		}
+		h.strip = append(h.strip, http.CanonicalHeaderKey(name))
+	}
+	return h, nil
+}
+
+func (h *responseHeaders) Apply(header http.Header) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.strip {
+		header.Del(name)
+	}
+	for key, values := range h.add {
+		header[key] = append(header[key], values...)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// tunnelRotator replaces the running tunnel with a new quick tunnel on request. cloudflared can't swap its
+// connector in process, so the new tunnel's credentials are stored and the callbacks notified first, then
+// the tunnel shuts down gracefully for the supervisor to restart it with them.
+type tunnelRotator struct {
+	c              *cli.Context
+	log            *zerolog.Logger
+	callbacks      *CallbackGroup
+	summary        *SummaryWebhook
+	metrics        MetricsRecorder
+	graceShutdownC chan struct{}
+
+	lock    sync.Mutex
+	url     string
+	rotated bool
+}
+
+func (r *tunnelRotator) Rotate() (*QuickTunnelConfig, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.rotated {
+		return nil, errors.New("tunnel URL was already rotated, waiting for the restart")
+	}
+	if r.c.Bool("readonly-credentials") {
+		return nil, errors.New("can't rotate the tunnel URL with --readonly-credentials, the new tunnel would be lost on the restart")
+	}
+	r.log.Info().Msg("Rotating tunnel URL")
+	config, err := createQuickTunnel(r.c, r.log, r.callbacks, r.summary, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rotate tunnel URL, keeping the current tunnel")
+	}
+	r.rotated = true
+	r.metrics.SetGauge(metricURLInfo, 0, map[string]string{"url": r.url})
+	r.url = quickTunnelURL(config.URL)
+	r.metrics.SetGauge(metricURLInfo, 1, map[string]string{"url": r.url})
+	r.log.Info().Msgf("Rotated tunnel URL to %s, restarting to connect with it", quickTunnelURL(config.URL))
+	requestShutdown(r.graceShutdownC)
+	return config, nil
+}
+
+// Rotated reports whether the tunnel shut down to switch to a new URL.
+func (r *tunnelRotator) Rotated() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rotated
+}
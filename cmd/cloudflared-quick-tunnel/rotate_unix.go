@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleRotateSignal rotates the tunnel URL on SIGUSR1.
+func handleRotateSignal(rotator *tunnelRotator) {
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGUSR1)
+	go func() {
+		for range signalC {
+			if _, err := rotator.Rotate(); err != nil {
+				rotator.log.Error().Msg(err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package main
+
+// There is no SIGUSR1 on Windows.
+func handleRotateSignal(rotator *tunnelRotator) {}
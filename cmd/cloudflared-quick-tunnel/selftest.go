@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// How often the selftest requests the public URL while waiting for it to answer.
+const selftestFetchInterval = time.Second
+
+// selftest runs a temporary quick tunnel in front of a one-shot origin, like cmd/test-server, and checks
+// that the origin answers on the public URL. It goes through the run path with everything it writes in
+// a temporary directory, and uses the summary webhook to learn the URL once the tunnel is connected.
+type selftest struct {
+	token string
+	// Path of the summary webhook, kept secret since the origin can be reached through the tunnel
+	eventsPath string
+	started    chan string
+	once       sync.Once
+}
+
+func Selftest(c *cli.Context, graceShutdownC chan struct{}) error {
+	if len(c.StringSlice("callback")) > 0 || c.String("shutdown-callback") != "" {
+		return errors.New("selftest would notify the callbacks of its temporary tunnel, unset --callback and --shutdown-callback")
+	}
+	log := createLogger(c, false)
+	dir, err := ioutil.TempDir("", "quick-tunnel-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	secrets := make([]byte, 32)
+	if _, err := rand.Read(secrets); err != nil {
+		return err
+	}
+	s := &selftest{
+		token:      hex.EncodeToString(secrets[:16]),
+		eventsPath: "/" + hex.EncodeToString(secrets[16:]),
+		started:    make(chan string, 1),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrap(err, "failed to start the selftest origin")
+	}
+	server := &http.Server{Handler: s}
+	go server.Serve(listener)
+	defer server.Close()
+	local := "http://" + listener.Addr().String()
+
+	overrides := map[string]string{
+		"url":               local,
+		"summary-webhook":   local + s.eventsPath,
+		"credentials":       filepath.Join(dir, "credentials.json"),
+		"pidfile":           filepath.Join(dir, "tunnel.pid"),
+		"url-history-file":  "",
+		"state-file":        "",
+		"readiness-address": "",
+		"dry-run":           "false",
+		"grace-period":      "1s",
+	}
+	for name, value := range overrides {
+		if err := c.Set(name, value); err != nil {
+			return errors.Wrapf(err, "failed to set --%s", name)
+		}
+	}
+
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- s.check(c, start, log)
+		requestShutdown(graceShutdownC)
+	}()
+	runErr := RunPersistentQuickTunnel(c, log, Version, graceShutdownC)
+	var checkErr error
+	select {
+	case checkErr = <-result:
+	default:
+		// The tunnel stopped before the check finished
+		checkErr = errors.New("the tunnel stopped before its URL answered")
+	}
+	if checkErr == nil {
+		return nil
+	}
+	if runErr != nil {
+		checkErr = runErr
+	}
+	fmt.Printf("FAIL after %s: %v\n", time.Since(start).Round(time.Millisecond), checkErr)
+	return cli.Exit("", 1)
+}
+
+// check waits for the tunnel to connect, then requests its public URL until the origin's token comes back.
+func (s *selftest) check(c *cli.Context, start time.Time, log *zerolog.Logger) error {
+	deadline := time.After(c.Duration("selftest-timeout"))
+	var url string
+	select {
+	case url = <-s.started:
+	case <-deadline:
+		return errors.Errorf("the tunnel didn't connect within --selftest-timeout %s", c.Duration("selftest-timeout"))
+	}
+	connected := time.Since(start)
+	log.Info().Msgf("Selftest: tunnel connected after %s, requesting %s", connected.Round(time.Millisecond), url)
+
+	client := &http.Client{Timeout: httpTimeout, Transport: outboundTransport(c)}
+	ticker := time.NewTicker(selftestFetchInterval)
+	defer ticker.Stop()
+	var fetchErr error
+	for {
+		if fetchErr = s.fetch(client, url); fetchErr == nil {
+			answered := time.Since(start)
+			fmt.Printf("PASS %s answered after %s (connected after %s, reachable %s later)\n", url,
+				answered.Round(time.Millisecond), connected.Round(time.Millisecond), (answered - connected).Round(time.Millisecond))
+			return nil
+		}
+		log.Debug().Err(fetchErr).Msg("Selftest: public URL not answering yet")
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return errors.Wrapf(fetchErr, "%s didn't answer within --selftest-timeout %s", url, c.Duration("selftest-timeout"))
+		}
+	}
+}
+
+func (s *selftest) fetch(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(len(s.token))+1))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != s.token {
+		return errors.Errorf("got %s %s instead of the selftest origin", resp.Status, responseSnippet(body))
+	}
+	return nil
+}
+
+// ServeHTTP is both the origin, which answers with the token, and the summary webhook.
+func (s *selftest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != s.eventsPath {
+		w.Write([]byte(s.token))
+		return
+	}
+	var event LifecycleEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if event.Event == eventStarted {
+		s.once.Do(func() {
+			s.started <- strings.TrimSuffix(event.URL, "/")
+		})
+	}
+}
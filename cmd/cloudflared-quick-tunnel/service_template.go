@@ -22,8 +22,13 @@ type ServiceTemplate struct {
 }
 
 type ServiceTemplateArgs struct {
-	Path      string
-	ExtraArgs []string
+	Path        string
+	ExtraArgs   []string
+	Environment []string
+	// Only set for units with secrets
+	EnvironmentFile string
+	// Only set for units that resolve relative paths
+	WorkingDirectory string
 }
 
 func (st *ServiceTemplate) ResolvePath() (string, error) {
@@ -34,25 +39,37 @@ func (st *ServiceTemplate) ResolvePath() (string, error) {
 	return resolvedPath, nil
 }
 
-func (st *ServiceTemplate) Generate(args *ServiceTemplateArgs) error {
+func (st *ServiceTemplate) Render(args *ServiceTemplateArgs) ([]byte, error) {
 	tmpl, err := template.New(st.Path).Parse(st.Content)
 	if err != nil {
-		return fmt.Errorf("error generating %s template: %v", st.Path, err)
+		return nil, fmt.Errorf("error generating %s template: %v", st.Path, err)
 	}
-	resolvedPath, err := st.ResolvePath()
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, args)
+	if err != nil {
+		return nil, fmt.Errorf("error generating %s: %v", st.Path, err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (st *ServiceTemplate) Generate(args *ServiceTemplateArgs) error {
+	content, err := st.Render(args)
 	if err != nil {
 		return err
 	}
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, args)
+	resolvedPath, err := st.ResolvePath()
 	if err != nil {
-		return fmt.Errorf("error generating %s: %v", st.Path, err)
+		return err
 	}
 	fileMode := os.FileMode(0644)
 	if st.FileMode != 0 {
 		fileMode = st.FileMode
+		// WriteFile only sets the mode of a new file
+		if err := os.Chmod(resolvedPath, fileMode); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error setting the mode of %s: %v", resolvedPath, err)
+		}
 	}
-	err = ioutil.WriteFile(resolvedPath, buffer.Bytes(), fileMode)
+	err = ioutil.WriteFile(resolvedPath, content, fileMode)
 	if err != nil {
 		return fmt.Errorf("error writing %s: %v", resolvedPath, err)
 	}
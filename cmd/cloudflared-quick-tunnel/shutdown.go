@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Exit status when --shutdown-timeout forces the exit, the one timeout(1) uses.
+const exitShutdownTimeout = 124
+
+// How much longer than --grace-period the graceful shutdown may take when --shutdown-timeout isn't set.
+const shutdownTimeoutMargin = time.Minute
+
+// requestShutdown starts the same graceful shutdown that SIGTERM does, so cloudflared drains connections for
+// the grace period before StartServer returns.
+func requestShutdown(graceShutdownC chan struct{}) {
+	process, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = process.Signal(syscall.SIGTERM)
+	}
+	if err == nil {
+		return
+	}
+	// Signalling ourselves isn't supported on Windows
+	select {
+	case <-graceShutdownC:
+	default:
+		close(graceShutdownC)
+	}
+}
+
+// shutdownTimeout is how long a graceful shutdown may take before the process is forced to exit.
+func shutdownTimeout(c *cli.Context) time.Duration {
+	if c.IsSet("shutdown-timeout") {
+		return c.Duration("shutdown-timeout")
+	}
+	return c.Duration("grace-period") + shutdownTimeoutMargin
+}
+
+// startShutdownTimer calls exit with exitShutdownTimeout if the process is still running timeout after the
+// graceful shutdown starts, in case draining or cleaning up hangs. The returned function stops it.
+func startShutdownTimer(timeout time.Duration, graceShutdownC chan struct{}, log *zerolog.Logger, exit func(code int)) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-graceShutdownC:
+		case <-done:
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Error().Msgf("Graceful shutdown didn't finish within --shutdown-timeout %s, exiting with status %d", timeout, exitShutdownTimeout)
+			exit(exitShutdownTimeout)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want time.Duration
+	}{
+		{name: "default grace period", want: 30*time.Second + shutdownTimeoutMargin},
+		{name: "follows --grace-period", args: []string{"--grace-period", "2m"}, want: 2*time.Minute + shutdownTimeoutMargin},
+		{name: "set independently", args: []string{"--grace-period", "2m", "--shutdown-timeout", "10m"}, want: 10 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := shutdownTimeout(runContext(t, test.args...)); got != test.want {
+				t.Errorf("shutdownTimeout() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShutdownTimer(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name     string
+		shutdown bool
+		stopped  bool
+		wantExit bool
+	}{
+		{name: "stuck shutdown is forced", shutdown: true, wantExit: true},
+		{name: "finished shutdown", shutdown: true, stopped: true},
+		{name: "no shutdown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exited := make(chan int, 1)
+			graceShutdownC := make(chan struct{})
+			stop := startShutdownTimer(timeout, graceShutdownC, testLog(), func(code int) { exited <- code })
+			if test.shutdown {
+				close(graceShutdownC)
+			}
+			if test.stopped {
+				stop()
+			}
+			select {
+			case code := <-exited:
+				if !test.wantExit {
+					t.Fatalf("exited with %d", code)
+				}
+				if code != exitShutdownTimeout {
+					t.Fatalf("exited with %d, want %d", code, exitShutdownTimeout)
+				}
+			case <-time.After(4 * timeout):
+				if test.wantExit {
+					t.Fatal("didn't exit")
+				}
+			}
+			if !test.stopped {
+				stop()
+			}
+		})
+	}
+}
+
+func TestValidateShutdownTimeout(t *testing.T) {
+	if err := validateRunFlags(runContext(t, "--shutdown-timeout", "-1s"), testLog()); err == nil {
+		t.Error("negative --shutdown-timeout was accepted")
+	}
+	if err := validateRunFlags(runContext(t, "--shutdown-timeout", "1s"), testLog()); err != nil {
+		t.Errorf("--shutdown-timeout shorter than --grace-period should only warn: %v", err)
+	}
+}
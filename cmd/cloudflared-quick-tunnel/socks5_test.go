@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/gobwas/ws/wsutil"
+	"golang.org/x/net/proxy"
+)
+
+// wsClientConn is the client end of a stream cloudflared proxies to a TCP origin, which it frames as WebSocket
+// messages. This is what cloudflared access tcp does on the client machine.
+type wsClientConn struct {
+	net.Conn
+	unread []byte
+}
+
+func (c *wsClientConn) Read(p []byte) (int, error) {
+	if len(c.unread) == 0 {
+		data, err := wsutil.ReadServerBinary(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		c.unread = data
+	}
+	n := copy(p, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+func (c *wsClientConn) Write(p []byte) (int, error) {
+	if err := wsutil.WriteClientBinary(c.Conn, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type dialerFunc func(network, address string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, address string) (net.Conn, error) {
+	return f(network, address)
+}
+
+// The run path hands --url and --socks5 to cloudflared, which serves SOCKS5 on the streams the edge proxies
+// and forwards the connections to the --url destination. The edge is replaced by a pipe here.
+func TestSocks5Origin(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	c := runContext(t, "--url", "tcp://"+target.Addr().String(), "--socks5")
+	if err := validateSocks5(c); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ingress.NewSingleOrigin(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	if err := rules.StartOrigins(&wg, testLog(), shutdownC, make(chan error, 1)); err != nil {
+		t.Fatal(err)
+	}
+	service, ok := rules.Rules[0].Service.(ingress.StreamBasedOriginProxy)
+	if !ok {
+		t.Fatalf("origin %s doesn't stream", rules.Rules[0].Service)
+	}
+	originConn, err := service.EstablishConnection(target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer originConn.Close()
+
+	tunnelConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer tunnelConn.Close()
+		originConn.Stream(ctx, tunnelConn, testLog())
+	}()
+
+	socks, err := proxy.SOCKS5("tcp", "tunnel", nil, dialerFunc(func(network, address string) (net.Conn, error) {
+		return &wsClientConn{Conn: clientConn}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := socks.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("target echoed %q, want %q", reply, "ping")
+	}
+}
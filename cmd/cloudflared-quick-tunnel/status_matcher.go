@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type statusRange struct {
+	min, max int
+}
+
+// statusMatcher matches HTTP status codes against a list such as "200-399,401".
+type statusMatcher []statusRange
+
+func parseStatusMatcher(spec string) (statusMatcher, error) {
+	var matcher statusMatcher
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		min, err := parseStatusCode(bounds[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid status %q", part)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = parseStatusCode(bounds[1]); err != nil {
+				return nil, errors.Wrapf(err, "invalid status range %q", part)
+			}
+			if max < min {
+				return nil, errors.Errorf("invalid status range %q, end is before start", part)
+			}
+		}
+		matcher = append(matcher, statusRange{min: min, max: max})
+	}
+	if len(matcher) == 0 {
+		return nil, errors.New("no status codes given")
+	}
+	return matcher, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if code < 100 || code > 599 {
+		return 0, errors.Errorf("%d is not an HTTP status code", code)
+	}
+	return code, nil
+}
+
+func (m statusMatcher) Match(code int) bool {
+	for _, r := range m {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
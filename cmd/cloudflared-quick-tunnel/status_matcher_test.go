@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStatusMatcher(t *testing.T) {
+	tests := []struct {
+		spec     string
+		wantErr  bool
+		match    []int
+		notMatch []int
+	}{
+		{spec: "200", match: []int{200}, notMatch: []int{201, 199}},
+		{spec: "200-399", match: []int{200, 302, 399}, notMatch: []int{199, 400}},
+		{spec: "200-299, 401", match: []int{204, 401}, notMatch: []int{400, 403}},
+		{spec: " 204 ,,302 ", match: []int{204, 302}, notMatch: []int{200}},
+		{spec: "404-404", match: []int{404}, notMatch: []int{403, 405}},
+		{spec: "", wantErr: true},
+		{spec: ",", wantErr: true},
+		{spec: "abc", wantErr: true},
+		{spec: "200-", wantErr: true},
+		{spec: "-200", wantErr: true},
+		{spec: "399-200", wantErr: true},
+		{spec: "99", wantErr: true},
+		{spec: "600", wantErr: true},
+		{spec: "200-600", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			matcher, err := parseStatusMatcher(test.spec)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			for _, code := range test.match {
+				if !matcher.Match(code) {
+					t.Errorf("%d doesn't match", code)
+				}
+			}
+			for _, code := range test.notMatch {
+				if matcher.Match(code) {
+					t.Errorf("%d matches", code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const stdinControlHelp = "commands: url, rotate, quit, reconnect [delay], help. reload is not supported"
+
+// Flags are only read at startup, so changing them takes a restart. reload is answered with this rather than as
+// an unknown command, so a parent process can tell it apart from a typo.
+const stdinControlReloadUnsupported = "reload not supported, restart the tunnel to apply changed flags"
+
+// stdinControl reads line commands from stdin for a parent process and replies to each on stdout with a line
+// starting with "ok" or "error". cloudflared reads stdin itself when --stdin-control is set, so it is given a
+// pipe instead, which reconnect commands are forwarded to.
+type stdinControl struct {
+	url            string
+	rotator        *tunnelRotator
+	graceShutdownC chan struct{}
+	log            *zerolog.Logger
+	cloudflared    io.Writer
+	out            io.Writer
+	lock           sync.Mutex
+}
+
+func startStdinControl(url string, rotator *tunnelRotator, graceShutdownC chan struct{}, log *zerolog.Logger) error {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to set up stdin control")
+	}
+	control := &stdinControl{
+		url:            url,
+		rotator:        rotator,
+		graceShutdownC: graceShutdownC,
+		log:            log,
+		cloudflared:    writer,
+		out:            os.Stdout,
+	}
+	stdin := os.Stdin
+	os.Stdin = reader
+	go control.serve(stdin)
+	return nil
+}
+
+func (s *stdinControl) serve(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s.handle(line)
+	}
+}
+
+func (s *stdinControl) handle(line string) {
+	parts := strings.SplitN(line, " ", 2)
+	switch parts[0] {
+	case "url":
+		s.reply("ok %s", s.url)
+	case "rotate":
+		config, err := s.rotator.Rotate()
+		if err != nil {
+			s.reply("error %s", err)
+			return
+		}
+		s.url = quickTunnelURL(config.URL)
+		s.reply("ok %s", s.url)
+	case "quit":
+		s.reply("ok")
+		requestShutdown(s.graceShutdownC)
+	case "reconnect":
+		if _, err := fmt.Fprintln(s.cloudflared, line); err != nil {
+			s.reply("error %s", err)
+			return
+		}
+		s.reply("ok")
+	case "reload":
+		s.reply("error %s", stdinControlReloadUnsupported)
+	case "help":
+		s.reply("ok %s", stdinControlHelp)
+	default:
+		s.reply("error unknown command %q, %s", parts[0], stdinControlHelp)
+	}
+}
+
+func (s *stdinControl) reply(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
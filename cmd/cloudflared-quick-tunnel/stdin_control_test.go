@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStdinControlHandle(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "url", want: "ok https://a.trycloudflare.com\n"},
+		{line: "help", want: "ok " + stdinControlHelp + "\n"},
+		{line: "reload", want: "error " + stdinControlReloadUnsupported + "\n"},
+		{line: "reload now", want: "error " + stdinControlReloadUnsupported + "\n"},
+		{line: "relaod", want: "error unknown command \"relaod\", " + stdinControlHelp + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			var out bytes.Buffer
+			control := &stdinControl{url: "https://a.trycloudflare.com", log: testLog(), out: &out}
+			control.handle(test.line)
+			if out.String() != test.want {
+				t.Fatalf("replied %q, want %q", out.String(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Lifecycle events sent to --summary-webhook.
+const (
+	eventStarted      = "started"
+	eventURLChanged   = "url_changed"
+	eventReconnected  = "reconnected"
+	eventShuttingDown = "shutting_down"
+	eventNoTraffic    = "no_traffic"
+	eventError        = "error"
+)
+
+// cloudflared logs this for every tunnel connection it registers with the edge.
+var connectionRegistered = regexp.MustCompile(`^Connection \S+ registered$`)
+
+type LifecycleEvent struct {
+	Event string `json:"event"`
+	URL   string `json:"url,omitempty"`
+	// Only set with --announce-both-schemes
+	HTTPURL string    `json:"http_url,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// SummaryWebhook posts tunnel lifecycle events to --summary-webhook in the order they happen. Sending is
+// done in the background so that a slow receiver does not hold up the tunnel. Its methods do nothing on a
+// nil SummaryWebhook.
+type SummaryWebhook struct {
+	notifier      *CallbackNotifier
+	haConnections int
+	bothSchemes   bool
+	log           *zerolog.Logger
+	events        chan LifecycleEvent
+	done          chan struct{}
+
+	lock        sync.Mutex
+	url         string
+	connections int
+	closed      bool
+}
+
+// NewSummaryWebhook returns nil if --summary-webhook is not set. Events carry the callback receiver's token.
+func NewSummaryWebhook(c *cli.Context, log *zerolog.Logger, token *callbackToken) (*SummaryWebhook, error) {
+	if c.String("summary-webhook") == "" {
+		return nil, nil
+	}
+	target, err := callbackTarget(c.String("url"), c.String("summary-webhook"))
+	if err != nil {
+		return nil, err
+	}
+	notifier := newCallbackNotifier(c, target, noRedirectClient(outboundTransport(c)))
+	notifier.token = token
+	w := &SummaryWebhook{
+		notifier:      notifier,
+		haConnections: c.Int("ha-connections"),
+		bothSchemes:   c.Bool("announce-both-schemes"),
+		log:           log,
+		events:        make(chan LifecycleEvent, 16),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *SummaryWebhook) run() {
+	defer close(w.done)
+	for event := range w.events {
+		payload, _ := json.Marshal(event)
+		if _, err := w.notifier.notify("application/json", payload); err != nil {
+			w.log.Err(err).Msgf("Failed to send %s event to summary webhook", event.Event)
+		}
+	}
+}
+
+func (w *SummaryWebhook) SetURL(url string) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	w.url = url
+	w.lock.Unlock()
+}
+
+// Send queues an event. err is only reported for error events.
+func (w *SummaryWebhook) Send(event string, err error) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	lifecycleEvent := LifecycleEvent{Event: event, URL: w.url, Time: time.Now().UTC()}
+	if w.bothSchemes && w.url != "" {
+		lifecycleEvent.HTTPURL = quickTunnelHTTPURL(w.url)
+	}
+	if err != nil {
+		lifecycleEvent.Error = err.Error()
+	}
+	select {
+	case w.events <- lifecycleEvent:
+	default:
+		w.log.Warn().Msgf("Summary webhook is falling behind, dropping %s event", event)
+	}
+}
+
+// Run is a zerolog hook that turns cloudflared's connection log lines into started and reconnected events.
+// The first registered connection means the tunnel is up; any beyond the initial --ha-connections are
+// replacing connections that dropped.
+func (w *SummaryWebhook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if !connectionRegistered.MatchString(msg) {
+		return
+	}
+	w.lock.Lock()
+	w.connections++
+	connections := w.connections
+	w.lock.Unlock()
+	if connections == 1 {
+		w.Send(eventStarted, nil)
+	} else if connections > w.haConnections {
+		w.Send(eventReconnected, nil)
+	}
+}
+
+// Close waits briefly for queued events to be delivered.
+func (w *SummaryWebhook) Close() {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	w.closed = true
+	close(w.events)
+	w.lock.Unlock()
+	select {
+	case <-w.done:
+	case <-time.After(httpTimeout):
+		w.log.Warn().Msg("Timed out delivering summary webhook events")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// openSyslog connects to the local syslog daemon, or to --syslog-addr, and sends each log line with the
+// severity of its level. Lines are only sent once the connection is up; if it drops later the syslog
+// package reconnects on the next line.
+func openSyslog(c *cli.Context) (io.Writer, error) {
+	network, address := "", ""
+	if addr := c.String("syslog-addr"); addr != "" {
+		network, address = "udp", addr
+		if i := strings.Index(addr, "://"); i >= 0 {
+			network, address = addr[:i], addr[i+3:]
+		}
+		if network != "udp" && network != "tcp" {
+			return nil, errors.Errorf("invalid --syslog-addr %q, expected udp:// or tcp://", addr)
+		}
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_DAEMON|syslog.LOG_INFO, c.String("syslog-tag"))
+	if err != nil {
+		return nil, err
+	}
+	return zerolog.SyslogLevelWriter(writer), nil
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+func openSyslog(c *cli.Context) (io.Writer, error) {
+	return nil, errors.New("--log-syslog is not supported on Windows")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+
+	"github.com/cloudflare/cloudflared/logger"
+)
+
+var quickTunnelSystemdTemplate = ServiceTemplate{
+	Path: "/etc/systemd/system/cloudflared-quick-tunnel.service",
+	Content: `[Unit]
+Description=Cloudflare Quick Tunnel
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+TimeoutStartSec=0
+Type=notify
+{{- if .WorkingDirectory }}
+WorkingDirectory={{ .WorkingDirectory }}
+{{- end }}
+{{- range .Environment }}
+Environment={{ . }}
+{{- end }}
+{{- if .EnvironmentFile }}
+EnvironmentFile={{ .EnvironmentFile }}
+{{- end }}
+ExecStart={{ .Path }} run{{ range .ExtraArgs }} {{ . }}{{ end }}
+Restart=always
+RestartSec=5s
+
+[Install]
+WantedBy=multi-user.target
+`,
+}
+
+// The unit file can be read by every user, so the secrets of the run flags are kept in this file, which only root
+// can read, and the unit expands them from the environment.
+var quickTunnelSystemdEnvTemplate = ServiceTemplate{
+	Path: "/etc/cloudflared-quick-tunnel.env",
+	Content: `# Secrets of cloudflared-quick-tunnel.service
+{{- range .Environment }}
+{{ . }}
+{{- end }}
+`,
+	FileMode: 0600,
+}
+
+// Flags of install-systemd that are not forwarded to the run command, along with those passed as environment variables.
+var systemdUnitOwnFlags = map[string]bool{
+	"install":     true,
+	"credentials": true,
+	"callback":    true,
+}
+
+// InstallSystemd prints, or with --install writes and enables, a systemd unit that runs the tunnel with the
+// given run flags. systemd restarting the process is what picks up regenerated credentials. Secrets are only
+// written with --install, to the environment file.
+func InstallSystemd(c *cli.Context) error {
+	templateArgs, secrets, err := systemdUnitArgs(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Bool("install") {
+		unit, err := quickTunnelSystemdTemplate.Render(templateArgs)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(unit); err != nil {
+			return err
+		}
+		if len(secrets) > 0 {
+			fmt.Fprintf(os.Stderr, "The secrets of the unit are written to %s, which only root can read, with --install\n", quickTunnelSystemdEnvTemplate.Path)
+		}
+		return nil
+	}
+
+	log := logger.CreateLoggerFromContext(c, logger.EnableTerminalLog)
+	if len(secrets) > 0 {
+		if err := quickTunnelSystemdEnvTemplate.Generate(&ServiceTemplateArgs{Environment: secrets}); err != nil {
+			log.Err(err).Msg("error generating service environment file")
+			return err
+		}
+		log.Info().Msgf("Wrote %s", quickTunnelSystemdEnvTemplate.Path)
+	}
+	if err := quickTunnelSystemdTemplate.Generate(templateArgs); err != nil {
+		log.Err(err).Msg("error generating service template")
+		return err
+	}
+	log.Info().Msgf("Wrote %s", quickTunnelSystemdTemplate.Path)
+	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+		log.Err(err).Msg("systemctl daemon-reload error")
+		return err
+	}
+	if err := runCommand("systemctl", "enable", "cloudflared-quick-tunnel.service"); err != nil {
+		log.Err(err).Msg("systemctl enable cloudflared-quick-tunnel.service error")
+		return err
+	}
+	log.Info().Msg("Enabled cloudflared-quick-tunnel.service, start it with: systemctl start cloudflared-quick-tunnel")
+	return nil
+}
+
+// systemdUnitArgs returns the arguments of the unit and the lines of its environment file, which holds the secrets.
+func systemdUnitArgs(c *cli.Context) (*ServiceTemplateArgs, []string, error) {
+	etPath, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error determining executable path: %v", err)
+	}
+	credentials, err := filepath.Abs(c.String("credentials"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error resolving credentials path")
+	}
+	if configFile := c.String("config"); configFile != "" {
+		absConfigFile, err := filepath.Abs(configFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error resolving config path")
+		}
+		c.Set("config", absConfigFile)
+	}
+	if workDir := c.String("work-dir"); workDir != "" {
+		absWorkDir, err := filepath.Abs(workDir)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error resolving work-dir path")
+		}
+		c.Set("work-dir", absWorkDir)
+	}
+	args, secrets, err := forwardedRunArgs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Relative paths of the run options are resolved as they would be from here
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error determining working directory")
+	}
+	templateArgs := &ServiceTemplateArgs{
+		Path:             etPath,
+		ExtraArgs:        args,
+		WorkingDirectory: workingDirectory,
+	}
+	// Without --credentials the tunnel keeps them in --work-dir
+	if c.IsSet("credentials") || c.String("work-dir") == "" {
+		templateArgs.Environment = append(templateArgs.Environment, systemdQuote("TUNNEL_CONFIG="+credentials))
+	}
+	if callbacks := c.StringSlice("callback"); len(callbacks) > 0 {
+		callbackVar, err := systemdEnvFileLine("CALLBACK", strings.Join(callbacks, ","))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid --callback")
+		}
+		secrets = append(secrets, callbackVar)
+	}
+	if len(secrets) > 0 {
+		templateArgs.EnvironmentFile = quickTunnelSystemdEnvTemplate.Path
+	}
+	return templateArgs, secrets, nil
+}
+
+// forwardedRunArgs renders every run flag that was set for this command so the unit starts the tunnel the same way.
+// An argument with a secret, which the diagnostic bundle would redact, is returned as a line of the environment
+// file instead, and the unit expands it from there.
+func forwardedRunArgs(c *cli.Context) (args []string, secrets []string, err error) {
+	forward := func(name, value string) error {
+		arg := fmt.Sprintf("--%s=%s", name, value)
+		if !isSensitiveFlag(name) && !headerValueFlags[name] && redactURL(value) == value {
+			args = append(args, systemdQuote(arg))
+			return nil
+		}
+		secretVar := fmt.Sprintf("QUICK_TUNNEL_SECRET_%d", len(secrets))
+		line, err := systemdEnvFileLine(secretVar, arg)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --%s", name)
+		}
+		secrets = append(secrets, line)
+		// The braces keep the value a single argument
+		args = append(args, "${"+secretVar+"}")
+		return nil
+	}
+	for _, f := range c.Command.Flags {
+		name := f.Names()[0]
+		if systemdUnitOwnFlags[name] || !c.IsSet(name) {
+			continue
+		}
+		switch f.(type) {
+		case *cli.StringSliceFlag, *altsrc.StringSliceFlag:
+			for _, value := range c.StringSlice(name) {
+				if err := forward(name, value); err != nil {
+					return nil, nil, err
+				}
+			}
+		default:
+			if err := forward(name, fmt.Sprint(c.Value(name))); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return args, secrets, nil
+}
+
+// systemdEnvFileLine renders a variable of the environment file, double-quoted the way systemd reads it.
+func systemdEnvFileLine(name, value string) (string, error) {
+	if strings.ContainsAny(value, "\n\r") {
+		return "", errors.New("a value spanning several lines can't be kept in a systemd environment file")
+	}
+	return name + `="` + systemdEnvFileEscaper.Replace(value) + `"`, nil
+}
+
+var systemdEnvFileEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+
+// systemdQuote quotes a unit file word if it contains characters systemd would otherwise split or interpret.
+func systemdQuote(word string) string {
+	if strings.ContainsAny(word, " \t\"'\\$%;") {
+		return strconv.Quote(word)
+	}
+	return word
+}
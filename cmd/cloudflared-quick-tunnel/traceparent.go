@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A W3C trace context traceparent: version, trace ID, parent span ID and flags.
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// The hex ray ID at the start of the CF-Ray header, before the colo, as in 6a1b2c3d4e5f6789-SJC.
+var cfRayPattern = regexp.MustCompile(`^[0-9a-f]{16}`)
+
+// ensureTraceparent gives requests without a valid traceparent header a new one, for --inject-traceparent.
+// Its trace ID is the request's ray ID padded to 128 bits, the way 64-bit trace IDs are, so a trace can be
+// found from the CF-Ray in the edge's logs. Without a CF-Ray the trace ID is random. The request is marked
+// sampled, so that the origin records it.
+func ensureTraceparent(header http.Header) {
+	if traceparent := header.Get("traceparent"); traceparentPattern.MatchString(traceparent) && !strings.HasPrefix(traceparent, "ff-") {
+		return
+	}
+	random := make([]byte, 24)
+	rand.Read(random)
+	traceID := hex.EncodeToString(random[:16])
+	if ray := cfRayPattern.FindString(strings.ToLower(header.Get("CF-Ray"))); ray != "" && ray != strings.Repeat("0", 16) {
+		traceID = strings.Repeat("0", 16) + ray
+	}
+	header.Set("traceparent", "00-"+traceID+"-"+hex.EncodeToString(random[16:])+"-01")
+}
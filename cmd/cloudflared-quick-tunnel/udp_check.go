@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/tlsconfig"
+)
+
+const (
+	udpCheckTimeout = 5 * time.Second
+	// Server name and ALPN cloudflared uses for QUIC connections to the edge.
+	edgeQUICServerName = "quic.cftunnel.com"
+	edgeQUICNextProto  = "argotunnel"
+	// quic-go pads the client's first packet to this many bytes (1232 over IPv6) and has no option to lower it.
+	quicInitialPacketSize = 1252
+)
+
+// edgeSRVService is the SRV service cloudflared looks up under argotunnel.com to discover edge addresses.
+func edgeSRVService(c *cli.Context) string {
+	if region := c.String("region"); region != "" {
+		return region + "-origintunneld"
+	}
+	return "origintunneld"
+}
+
+// resolveEdgeAddrs returns the --edge addresses, or otherwise the ones found by edge discovery.
+func resolveEdgeAddrs(c *cli.Context) ([]string, error) {
+	if edgeAddrs := c.StringSlice("edge"); len(edgeAddrs) > 0 {
+		return edgeAddrs, nil
+	}
+	_, srvs, err := net.LookupSRV(edgeSRVService(c), "tcp", "argotunnel.com")
+	if err != nil {
+		return nil, errors.Wrap(err, "edge discovery failed")
+	}
+	var addrs []string
+	for _, srv := range srvs {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+	}
+	return addrs, nil
+}
+
+// checkUDP attempts a QUIC handshake with the edge before starting a QUIC tunnel. On networks that block
+// UDP cloudflared would otherwise keep retrying without saying why.
+func checkUDP(c *cli.Context, log *zerolog.Logger) error {
+	addrs, err := resolveEdgeAddrs(c)
+	if err != nil {
+		log.Warn().Msgf("Skipping UDP check: %s", err)
+		return nil
+	}
+	tlsConfig, err := tlsconfig.CreateTunnelConfig(c, edgeQUICServerName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create TLS config for UDP check")
+	}
+	tlsConfig.NextProtos = []string{edgeQUICNextProto}
+
+	candidates := edgeCandidates(addrs, log)
+	var attempts []edgeDialAttempt
+	if c.String("check-udp-source-port") == "" {
+		attempts = dialEdgeCandidates(c, candidates, tlsConfig, log)
+	} else {
+		// The attempts share the --check-udp-source-port range, so they are made one at a time
+		for _, addr := range candidates {
+			attempt := dialEdgeCandidate(c, addr, tlsConfig, log)
+			attempts = append(attempts, attempt)
+			if attempt.err == nil {
+				break
+			}
+		}
+	}
+	handshakeErr := logEdgeDialAttempts(attempts, log)
+	if handshakeErr == nil {
+		return nil
+	}
+	err = errors.Errorf("could not complete a QUIC handshake with the edge (%s), UDP is probably blocked on this network or the path MTU is below the %d bytes QUIC handshake packets need. Use --protocol http2 instead", handshakeErr, quicInitialPacketSize)
+	if c.Bool("fail-fast-on-udp-block") {
+		return err
+	}
+	log.Warn().Msg(err.Error())
+	return nil
+}
+
+func quicHandshake(c *cli.Context, addr string, tlsConfig *tls.Config, log *zerolog.Logger) error {
+	timeout := c.Duration("edge-dial-timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	quicConfig := &quic.Config{HandshakeIdleTimeout: timeout}
+	conn, err := listenCheckUDP(c)
+	if err != nil {
+		return err
+	}
+	var session quic.Session
+	if conn == nil {
+		session, err = quic.DialAddrContext(ctx, addr, tlsConfig, quicConfig)
+	} else {
+		defer conn.Close()
+		log.Info().Msgf("UDP check: sending from %s", conn.LocalAddr())
+		var udpAddr *net.UDPAddr
+		if udpAddr, err = net.ResolveUDPAddr("udp", addr); err != nil {
+			return err
+		}
+		session, err = quic.DialContext(ctx, conn, udpAddr, edgeQUICServerName, tlsConfig, quicConfig)
+	}
+	if err != nil {
+		return err
+	}
+	return session.CloseWithError(0, "")
+}
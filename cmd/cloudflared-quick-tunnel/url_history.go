@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// URLHistoryEntry is a line of --url-history-file, written every time the tunnel announces a URL.
+type URLHistoryEntry struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	TunnelID string    `json:"tunnel_id"`
+	New      bool      `json:"new"`
+}
+
+func urlHistoryFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "url-history-file",
+		Usage:   "`FILE` that a JSON line with the time, URL, tunnel ID and whether it is new is appended to for every URL the tunnel uses",
+		EnvVars: []string{"TUNNEL_URL_HISTORY_FILE"},
+	}
+}
+
+// appendURLHistory records a URL in --url-history-file, if it is set.
+func appendURLHistory(c *cli.Context, config *QuickTunnelConfig, isNew bool) error {
+	path := c.String("url-history-file")
+	if path == "" {
+		return nil
+	}
+	line, _ := json.Marshal(URLHistoryEntry{
+		Time:     time.Now().UTC(),
+		URL:      quickTunnelURL(config.URL),
+		TunnelID: config.Credentials.TunnelID.String(),
+		New:      isNew,
+	})
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open --url-history-file")
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write --url-history-file")
+	}
+	return file.Close()
+}
+
+// PrintURLHistory prints --url-history-file as a table, oldest first.
+func PrintURLHistory(c *cli.Context) error {
+	path := c.String("url-history-file")
+	if path == "" {
+		return cli.Exit("--url-history-file is required", 1)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	defer file.Close()
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tURL\tTUNNEL ID\tCREATED")
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var entry URLHistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return cli.Exit(fmt.Sprintf("%s:%d: %v", path, line, err), 1)
+		}
+		created := "reused"
+		if entry.New {
+			created = "new"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", entry.Time.Local().Format(time.RFC3339), entry.URL, entry.TunnelID, created)
+	}
+	if err := scanner.Err(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return table.Flush()
+}
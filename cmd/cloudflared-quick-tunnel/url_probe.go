@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// URLProbe periodically requests the public tunnel URL and shuts the tunnel down once it has failed to respond
+// a number of times in a row, so the supervisor restarts it. This catches failures the edge connection status
+// doesn't show. Probing starts once the first connection is registered, as the URL can't respond before that.
+// Its methods do nothing on a nil URLProbe.
+type URLProbe struct {
+	path        string
+	interval    time.Duration
+	maxFailures int
+	healthy     statusMatcher
+	client      *http.Client
+	log         *zerolog.Logger
+	registered  chan struct{}
+
+	url          string
+	registerOnce sync.Once
+	lock         sync.Mutex
+	failed       bool
+}
+
+// NewURLProbe returns nil if --probe-interval isn't set.
+func NewURLProbe(c *cli.Context, log *zerolog.Logger) (*URLProbe, error) {
+	interval := c.Duration("probe-interval")
+	if interval <= 0 {
+		return nil, nil
+	}
+	healthy, err := parseStatusMatcher(c.String("origin-healthy-status"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --origin-healthy-status")
+	}
+	return &URLProbe{
+		path:        strings.TrimPrefix(c.String("probe-path"), "/"),
+		interval:    interval,
+		maxFailures: c.Int("probe-failures"),
+		healthy:     healthy,
+		client: &http.Client{
+			Timeout:   httpTimeout,
+			Transport: outboundTransport(c),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		log:        log,
+		registered: make(chan struct{}),
+	}, nil
+}
+
+// Start probes tunnelURL from the first registered connection until the graceful shutdown starts.
+func (p *URLProbe) Start(tunnelURL string, graceShutdownC chan struct{}) {
+	if p == nil {
+		return
+	}
+	p.url = strings.TrimSuffix(tunnelURL, "/") + "/" + p.path
+	go p.run(graceShutdownC)
+}
+
+// Run is a zerolog hook that starts probing on cloudflared's first registered connection.
+func (p *URLProbe) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if connectionRegistered.MatchString(msg) {
+		p.registerOnce.Do(func() {
+			close(p.registered)
+		})
+	}
+}
+
+// run probes until graceShutdownC is closed or the URL has failed too many times, in which case it requests a
+// shutdown.
+func (p *URLProbe) run(graceShutdownC chan struct{}) {
+	select {
+	case <-graceShutdownC:
+		return
+	case <-p.registered:
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-graceShutdownC:
+			return
+		case <-ticker.C:
+		}
+		if p.probe() {
+			failures = 0
+			continue
+		}
+		failures++
+		if failures >= p.maxFailures {
+			p.log.Error().Msgf("%s failed %d consecutive probes, shutting down so the tunnel is restarted", p.url, failures)
+			p.lock.Lock()
+			p.failed = true
+			p.lock.Unlock()
+			requestShutdown(graceShutdownC)
+			return
+		}
+	}
+}
+
+func (p *URLProbe) probe() bool {
+	start := time.Now()
+	resp, err := p.client.Head(p.url)
+	if err != nil {
+		p.log.Debug().Err(err).Str("url", p.url).Msg("URL probe failed")
+		return false
+	}
+	resp.Body.Close()
+	p.log.Debug().Str("url", p.url).Int("status", resp.StatusCode).Dur("latency", time.Since(start)).Msg("URL probe")
+	return p.healthy.Match(resp.StatusCode)
+}
+
+// Failed reports whether the probe shut the tunnel down.
+func (p *URLProbe) Failed() bool {
+	if p == nil {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.failed
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestURLProbeStartsOnRegisteredConnection(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	tests := []struct {
+		name       string
+		messages   []string
+		wantProbes bool
+	}{
+		{name: "no connection", wantProbes: false},
+		{name: "connection failing", messages: []string{"Connection terminated", "Retrying connection in up to 2s seconds"}, wantProbes: false},
+		{name: "connection registered", messages: []string{"Connection 0b8a registered"}, wantProbes: true},
+		{name: "connections re-registered", messages: []string{"Connection 0b8a registered", "Connection terminated", "Connection 0b8a registered"}, wantProbes: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var paths []string
+			tunnel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				paths = append(paths, r.URL.Path)
+			}))
+			defer tunnel.Close()
+			probe, err := NewURLProbe(runContext(t, "--probe-interval", interval.String(), "--probe-path", "/healthz"), testLog())
+			if err != nil {
+				t.Fatal(err)
+			}
+			graceShutdownC := make(chan struct{})
+			defer close(graceShutdownC)
+			probe.Start(tunnel.URL, graceShutdownC)
+			for _, msg := range test.messages {
+				probe.Run(nil, zerolog.InfoLevel, msg)
+			}
+			time.Sleep(10 * interval)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if probed := len(paths) > 0; probed != test.wantProbes {
+				t.Fatalf("probed %v, want %v", probed, test.wantProbes)
+			}
+			for _, path := range paths {
+				if path != "/healthz" {
+					t.Fatalf("probed %s, want /healthz", path)
+				}
+			}
+		})
+	}
+}
+
+func TestURLProbeAtErrorLogLevel(t *testing.T) {
+	probed := make(chan struct{}, 1)
+	tunnel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case probed <- struct{}{}:
+		default:
+		}
+	}))
+	defer tunnel.Close()
+	c := runContext(t, "--probe-interval", "10ms", "--loglevel", "error", "--logfile", filepath.Join(t.TempDir(), "quick-tunnel.log"))
+	log := createLogger(c, true)
+	probe, err := NewURLProbe(c, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hookedLog := log.Hook(probe)
+	graceShutdownC := make(chan struct{})
+	defer close(graceShutdownC)
+	probe.Start(tunnel.URL, graceShutdownC)
+	hookedLog.Info().Msg("Connection 0b8a registered")
+	select {
+	case <-probed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the URL wasn't probed at --loglevel error")
+	}
+}
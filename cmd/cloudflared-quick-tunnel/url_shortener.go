@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Largest shortener response that is read.
+const maxShortenerResponse = 64 * 1024
+
+// shortenTunnelURL asks the --shorten-with API for a short link to the tunnel URL and stores it in the config,
+// so a reused tunnel keeps its link. Shortening is best effort: on failure the long URL is all there is.
+func shortenTunnelURL(c *cli.Context, config *QuickTunnelConfig, log *zerolog.Logger) {
+	api := c.String("shorten-with")
+	if api == "" || config.ShortURL != "" {
+		return
+	}
+	shortURL, err := requestShortURL(noRedirectClient(outboundTransport(c)), api, c.String("shorten-field"), quickTunnelURL(config.URL))
+	if err != nil {
+		log.Warn().Msgf("Failed to shorten the tunnel URL, using the long URL: %v", err)
+		return
+	}
+	config.ShortURL = shortURL
+}
+
+// requestShortURL posts {"url": longURL} to the shortener and reads the short link from the response field at
+// path, a dot-separated list of keys such as data.short_url.
+func requestShortURL(client *http.Client, api, path, longURL string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"url": longURL})
+	resp, err := client.Post(api, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxShortenerResponse))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", errors.Errorf("shortener responded %s %s", resp.Status, responseSnippet(respBody))
+	}
+	var value interface{}
+	if err := json.Unmarshal(respBody, &value); err != nil {
+		return "", errors.Wrapf(err, "invalid shortener response %s", responseSnippet(respBody))
+	}
+	value, ok := lookupJSONPath(value, path)
+	if !ok {
+		return "", errors.Errorf("shortener response has no --shorten-field %s", path)
+	}
+	shortURL, ok := value.(string)
+	if !ok {
+		return "", errors.Errorf("--shorten-field %s of the shortener response is not a string", path)
+	}
+	if u, err := url.Parse(shortURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.Errorf("--shorten-field %s of the shortener response is not a URL: %q", path, shortURL)
+	}
+	return shortURL, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/tlsconfig"
+)
+
+// Flags accepted for compatibility with cloudflared that have no effect on a quick tunnel, and why.
+var noEffectFlags = map[string]string{
+	"max-fetch-size":      "quick tunnels do not list anything from the Cloudflare API",
+	"use-reconnect-token": "cloudflared only resumes classic tunnel connections with a reconnect token, quick tunnel connections are always registered anew",
+}
+
+// validateRunFlags checks flag values that cloudflared would otherwise only reject, or silently ignore, once the tunnel is starting.
+func validateRunFlags(c *cli.Context, log *zerolog.Logger) error {
+	for name, reason := range noEffectFlags {
+		if c.IsSet(name) {
+			log.Warn().Msgf("--%s has no effect: %s", name, reason)
+		}
+	}
+	if err := validateEdgeAddrs(c, log); err != nil {
+		return err
+	}
+	if err := validateRegion(c); err != nil {
+		return err
+	}
+	if err := validateOriginTLS(c, log); err != nil {
+		return err
+	}
+	if err := validateHeartbeat(c, log); err != nil {
+		return err
+	}
+	if err := validateSocks5(c); err != nil {
+		return err
+	}
+	if err := validateLocalAddress(c, log); err != nil {
+		return err
+	}
+	if err := validateCheckUDPSourcePort(c); err != nil {
+		return err
+	}
+	if _, err := parseOutboundProxy(c.String("outbound-proxy")); err != nil {
+		return err
+	}
+	if shortener := c.String("shorten-with"); shortener != "" {
+		if u, err := url.Parse(shortener); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid --shorten-with %q, expected an http or https URL", shortener)
+		}
+	}
+	if c.String(tlsconfig.OriginCAPoolFlag) != "" {
+		if _, err := loadOriginCAPool(c, log); err != nil {
+			return err
+		}
+	}
+	if c.Duration("edge-dial-timeout") <= 0 {
+		return errors.New("--edge-dial-timeout must be positive")
+	}
+	if c.Float64("rate-limit") < 0 || c.Int("rate-limit-burst") < 0 {
+		return errors.New("--rate-limit and --rate-limit-burst can't be negative")
+	}
+	if c.Float64("rate-limit") == 0 && (c.IsSet("rate-limit-burst") || c.Bool("rate-limit-per-ip")) {
+		return errors.New("--rate-limit-burst and --rate-limit-per-ip require --rate-limit")
+	}
+	if c.Int("max-concurrent-requests") < 0 || c.Int("max-concurrent-queue") < 0 || c.Duration("max-concurrent-timeout") <= 0 {
+		return errors.New("--max-concurrent-requests and --max-concurrent-queue can't be negative, and --max-concurrent-timeout must be positive")
+	}
+	if c.Int("max-concurrent-requests") == 0 && (c.IsSet("max-concurrent-queue") || c.IsSet("max-concurrent-timeout")) {
+		return errors.New("--max-concurrent-queue and --max-concurrent-timeout require --max-concurrent-requests")
+	}
+	if c.Duration("origin-breaker-window") <= 0 {
+		return errors.New("--origin-breaker-window must be positive")
+	}
+	if c.Int("callback-retry-max") < 0 {
+		return errors.New("--callback-retry-max can't be negative")
+	}
+	if c.Int("request-retries") < 0 {
+		return errors.New("--request-retries can't be negative")
+	}
+	if c.Bool("fail-on-existing") && c.Bool("force-new") {
+		return errors.New("--fail-on-existing and --force-new are contradictory")
+	}
+	if _, err := parseQuickServiceResponseMap(c.StringSlice("response-map")); err != nil {
+		return err
+	}
+	if _, err := loadOriginClientCert(c); err != nil {
+		return err
+	}
+	if _, err := parseTLSVersion(c.String("origin-min-tls-version")); err != nil {
+		return err
+	}
+	switch version := c.String("origin-ip-version"); version {
+	case "auto", "4", "6":
+	default:
+		return errors.Errorf("invalid --origin-ip-version %q, expected auto, 4 or 6", version)
+	}
+	switch format := c.String("credentials-format"); format {
+	case credentialsFormatJSON, credentialsFormatJSONBase64:
+	default:
+		return errors.Errorf("invalid --credentials-format %q, expected %s or %s", format, credentialsFormatJSON, credentialsFormatJSONBase64)
+	}
+	if c.Bool("credentials-encrypt") && c.IsSet("credentials-format") {
+		return errors.New("--credentials-encrypt and --credentials-format are contradictory, an encrypted file has a format of its own")
+	}
+	if c.Bool("readonly-credentials") && c.Bool("force-new") {
+		return errors.New("--force-new can't replace the stored tunnel with --readonly-credentials")
+	}
+	if c.IsSet("shutdown-timeout") {
+		if c.Duration("shutdown-timeout") <= 0 {
+			return errors.New("--shutdown-timeout must be positive")
+		}
+		if c.Duration("shutdown-timeout") <= c.Duration("grace-period") {
+			log.Warn().Msgf("--shutdown-timeout %s isn't longer than --grace-period %s, requests may not finish draining before the exit is forced", c.Duration("shutdown-timeout"), c.Duration("grace-period"))
+		}
+	}
+	if c.Duration("watchdog-timeout") < 0 {
+		return errors.New("--watchdog-timeout can't be negative")
+	}
+	if c.Duration("probe-interval") > 0 && c.Int("probe-failures") < 1 {
+		return errors.New("--probe-failures must be at least 1")
+	}
+	if _, err := parseStatusMatcher(c.String("origin-healthy-status")); err != nil {
+		return errors.Wrap(err, "invalid --origin-healthy-status")
+	}
+	return nil
+}
+
+func validateHeartbeat(c *cli.Context, log *zerolog.Logger) error {
+	if c.Duration("heartbeat-interval") <= 0 {
+		return errors.New("--heartbeat-interval must be positive")
+	}
+	if c.Int("heartbeat-count") <= 0 {
+		return errors.New("--heartbeat-count must be positive")
+	}
+	if (c.IsSet("heartbeat-interval") || c.IsSet("heartbeat-count")) && c.String("protocol") != connection.H2mux.String() {
+		log.Warn().Msgf("--heartbeat-interval and --heartbeat-count only apply to the %s protocol", connection.H2mux)
+	}
+	return nil
+}
+
+func validateOriginTLS(c *cli.Context, log *zerolog.Logger) error {
+	if !c.Bool(ingress.NoTLSVerifyFlag) {
+		return nil
+	}
+	if c.String(tlsconfig.OriginCAPoolFlag) != "" {
+		return errors.Errorf("--%s and --%s are contradictory: the CA pool is only used to verify the origin certificate", ingress.NoTLSVerifyFlag, tlsconfig.OriginCAPoolFlag)
+	}
+	log.Warn().Msgf("--%s is set: any certificate presented by the origin will be accepted. Use --%s to trust a self-signed origin certificate instead", ingress.NoTLSVerifyFlag, tlsconfig.OriginCAPoolFlag)
+	return nil
+}
+
+func validateEdgeAddrs(c *cli.Context, log *zerolog.Logger) error {
+	edgeAddrs := c.StringSlice("edge")
+	if len(edgeAddrs) == 0 {
+		return nil
+	}
+	for _, addr := range edgeAddrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --edge address %q, expected HOST:PORT", addr)
+		}
+		if host == "" {
+			return errors.Errorf("invalid --edge address %q, missing host", addr)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return errors.Errorf("invalid --edge address %q, port must be between 1 and 65535", addr)
+		}
+	}
+	if c.String("region") != "" {
+		log.Warn().Msgf("--region %s is ignored because --edge is set", c.String("region"))
+	}
+	log.Info().Strs("edgeAddrs", edgeAddrs).Msg("Using edge address override instead of edge discovery")
+	return nil
+}
+
+// Edge regions with their own SRV records, which cloudflared looks up as <region>-origintunneld. cloudflared
+// doesn't keep a list of them.
+var edgeRegions = []string{"us"}
+
+func validateRegion(c *cli.Context) error {
+	region := c.String("region")
+	if region == "" {
+		return nil
+	}
+	for _, known := range edgeRegions {
+		if region == known {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid --region %q, valid regions are: %s. Omit it for the global region", region, strings.Join(edgeRegions, ", "))
+}
+
+// validateSocks5 checks the origin --socks5 is used with. cloudflared only runs the SOCKS5 server for a TCP
+// origin and would otherwise silently proxy http to --url, or to the default http://localhost:8080.
+func validateSocks5(c *cli.Context) error {
+	if !c.Bool(ingress.Socks5Flag) {
+		return nil
+	}
+	if c.IsSet("hello-world") || c.IsSet("unix-socket") {
+		return errors.Errorf("--%s cannot be combined with --hello-world or --unix-socket", ingress.Socks5Flag)
+	}
+	if !c.IsSet("url") {
+		return errors.Errorf("--%s requires --url tcp://HOST:PORT, the destination SOCKS5 connections are forwarded to", ingress.Socks5Flag)
+	}
+	origin, err := url.Parse(c.String("url"))
+	if err != nil {
+		return errors.Wrap(err, "invalid --url")
+	}
+	if origin.Scheme == "http" || origin.Scheme == "https" {
+		return errors.Errorf("--%s requires a tcp:// --url, got %q", ingress.Socks5Flag, c.String("url"))
+	}
+	return nil
+}
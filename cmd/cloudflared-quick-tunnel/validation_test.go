@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestValidateOriginTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantErr  bool
+		wantWarn bool
+	}{
+		{name: "verified origin"},
+		{name: "--origin-ca-pool alone", args: []string{"--origin-ca-pool", "ca.pem"}},
+		{name: "--no-tls-verify warns", args: []string{"--no-tls-verify"}, wantWarn: true},
+		{name: "--no-tls-verify with --origin-ca-pool", args: []string{"--no-tls-verify", "--origin-ca-pool", "ca.pem"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := zerolog.New(&out)
+			err := validateOriginTLS(runContext(t, test.args...), &log)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if warned := strings.Contains(out.String(), "any certificate presented by the origin"); warned != test.wantWarn {
+				t.Fatalf("got warning %v, want %v: %s", warned, test.wantWarn, out.String())
+			}
+		})
+	}
+}
+
+func TestValidateSocks5(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "without --socks5", args: []string{"--url", "http://localhost:8080"}},
+		{name: "tcp origin", args: []string{"--socks5", "--url", "tcp://localhost:1080"}},
+		{name: "default origin", args: []string{"--socks5"}, wantErr: true},
+		{name: "http origin", args: []string{"--socks5", "--url", "http://localhost:8080"}, wantErr: true},
+		{name: "https origin", args: []string{"--socks5", "--url", "https://localhost:8443"}, wantErr: true},
+		{name: "hello world", args: []string{"--socks5", "--hello-world"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateSocks5(runContext(t, test.args...)); (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCheckUDPSourcePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "unset", args: []string{}},
+		{name: "port", args: []string{"--check-udp", "--check-udp-source-port", "7000"}},
+		{name: "range", args: []string{"--check-udp", "--check-udp-source-port", "7000-7010"}},
+		{name: "with --fail-fast-on-udp-block", args: []string{"--fail-fast-on-udp-block", "--check-udp-source-port", "7000"}},
+		{name: "without a UDP check", args: []string{"--check-udp-source-port", "7000"}, wantErr: true},
+		{name: "reversed range", args: []string{"--check-udp", "--check-udp-source-port", "7010-7000"}, wantErr: true},
+		{name: "out of range", args: []string{"--check-udp", "--check-udp-source-port", "70000"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateCheckUDPSourcePort(runContext(t, test.args...)); (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+const cloudflaredModule = "github.com/cloudflare/cloudflared"
+
+// Modules listed by version --verbose: cloudflared, where most connectivity behavior comes from, and the
+// libraries its connections and the command line are built on.
+var verboseVersionModules = []string{cloudflaredModule, "github.com/lucas-clemente/quic-go", "github.com/urfave/cli/v2"}
+
+type versionInfo struct {
+	Version     string `json:"version"`
+	BuildTime   string `json:"build_time"`
+	GoVersion   string `json:"go_version"`
+	Cloudflared string `json:"cloudflared,omitempty"`
+	// Set with --verbose, from module path to version.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// PrintVersionJSON prints the version for tooling, including the cloudflared library it was built with.
+// With verbose it adds the versions of the verboseVersionModules.
+func PrintVersionJSON(verbose bool) error {
+	info := versionInfo{
+		Version:     Version,
+		BuildTime:   BuildTime,
+		GoVersion:   runtime.Version(),
+		Cloudflared: cloudflaredVersion(),
+	}
+	if verbose {
+		info.Dependencies = make(map[string]string)
+		for _, path := range verboseVersionModules {
+			if dep := findModule(path); dep != nil {
+				info.Dependencies[path] = moduleVersion(dep)
+			}
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	return encoder.Encode(info)
+}
+
+// PrintVersionDependencies prints the Go version and the verboseVersionModules the binary was built with,
+// after the plain version line for bug reports. A replaced module is printed with its replacement.
+func PrintVersionDependencies() {
+	fmt.Printf("go: %s\n", runtime.Version())
+	for _, path := range verboseVersionModules {
+		dep := findModule(path)
+		switch {
+		case dep == nil:
+			fmt.Printf("%s: unknown\n", path)
+		case dep.Replace != nil:
+			fmt.Printf("%s: %s => %s %s\n", path, dep.Version, dep.Replace.Path, dep.Replace.Version)
+		default:
+			fmt.Printf("%s: %s\n", path, dep.Version)
+		}
+	}
+}
+
+// cloudflaredVersion is the module version of the embedded cloudflared, or "" if the binary was built
+// without module information.
+func cloudflaredVersion() string {
+	if dep := findModule(cloudflaredModule); dep != nil {
+		return moduleVersion(dep)
+	}
+	return ""
+}
+
+// findModule returns the dependency the binary was built with for a module path, or nil if the binary
+// was built without module information or doesn't depend on it.
+func findModule(path string) *debug.Module {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep
+		}
+	}
+	return nil
+}
+
+// moduleVersion is the version of a dependency that was actually built, taking replacements into account.
+func moduleVersion(dep *debug.Module) string {
+	if dep.Replace != nil {
+		return dep.Replace.Version
+	}
+	return dep.Version
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// cloudflared logs these while a connection to the edge is failing and being retried.
+var connectionRetrying = regexp.MustCompile(`^(Connection terminated|Retrying connection in up to .*|Unable to establish connection.*|Serve tunnel error|Register tunnel error from server side|Failed to (create new|serve) quic connection)$`)
+
+// watchdog exits the process when cloudflared's connector stops making progress without exiting, so the
+// supervisor restarts it. cloudflared doesn't report connection health beyond its log, so the heartbeat is
+// its connection log lines: registering a connection means it is connected, and each failure or retry
+// means it is still trying. If --watchdog-timeout passes after a failure, or after startup, with neither,
+// the goroutine stacks are written to stderr for the bug report and the process exits with status 1.
+// While connected nothing is logged, so the watchdog only watches a connector that is trying to connect.
+// Its methods do nothing on a nil watchdog.
+type watchdog struct {
+	timeout time.Duration
+	log     *zerolog.Logger
+	stacks  io.Writer
+	exit    func(code int)
+
+	lock    sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+// newWatchdog returns nil unless --watchdog-timeout is set.
+func newWatchdog(c *cli.Context, log *zerolog.Logger) *watchdog {
+	if c.Duration("watchdog-timeout") <= 0 {
+		return nil
+	}
+	return &watchdog{timeout: c.Duration("watchdog-timeout"), log: log, stacks: os.Stderr, exit: os.Exit}
+}
+
+// Start arms the watchdog for the first connection and disarms it for good once the graceful shutdown starts.
+func (w *watchdog) Start(graceShutdownC chan struct{}) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	w.timer = time.AfterFunc(w.timeout, w.stalled)
+	w.lock.Unlock()
+	go func() {
+		<-graceShutdownC
+		w.Stop()
+	}()
+}
+
+// Run is a zerolog hook that feeds the watchdog with cloudflared's connection log lines.
+func (w *watchdog) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	switch {
+	case connectionRegistered.MatchString(msg):
+		w.beat(false)
+	case connectionRetrying.MatchString(msg):
+		w.beat(true)
+	}
+}
+
+// beat disarms the watchdog once a connection is registered, and rearms it while connections are retried.
+func (w *watchdog) beat(retrying bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timer == nil || w.stopped {
+		return
+	}
+	if retrying {
+		w.timer.Reset(w.timeout)
+	} else {
+		w.timer.Stop()
+	}
+}
+
+func (w *watchdog) stalled() {
+	w.lock.Lock()
+	stopped := w.stopped
+	w.lock.Unlock()
+	if stopped {
+		return
+	}
+	w.log.Error().Msgf("The connector made no progress connecting to the edge for %s, see the goroutine stacks below. Exiting so the tunnel is restarted", w.timeout)
+	pprof.Lookup("goroutine").WriteTo(w.stacks, 2)
+	w.exit(1)
+}
+
+func (w *watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWatchdog(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name     string
+		messages []string
+		wantExit bool
+	}{
+		{name: "no connection after startup", wantExit: true},
+		{name: "connection registered", messages: []string{"Connection 0b8a registered"}, wantExit: false},
+		{name: "connection failing after registering", messages: []string{"Connection 0b8a registered", "Connection terminated"}, wantExit: true},
+		{name: "connection re-registered after failing", messages: []string{"Connection terminated", "Retrying connection in up to 2s seconds", "Connection 0b8a registered"}, wantExit: false},
+		{name: "unrelated log lines", messages: []string{"Starting metrics server", "Callback notified"}, wantExit: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exited := make(chan int, 1)
+			w := &watchdog{timeout: timeout, log: testLog(), stacks: ioutil.Discard, exit: func(code int) { exited <- code }}
+			w.Start(make(chan struct{}))
+			defer w.Stop()
+			for _, msg := range test.messages {
+				w.Run(nil, zerolog.InfoLevel, msg)
+			}
+			select {
+			case code := <-exited:
+				if !test.wantExit {
+					t.Fatalf("watchdog exited with %d", code)
+				}
+			case <-time.After(4 * timeout):
+				if test.wantExit {
+					t.Fatal("watchdog didn't exit")
+				}
+			}
+		})
+	}
+}
+
+func TestWatchdogRetriesKeepItArmed(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	exited := make(chan int, 1)
+	w := &watchdog{timeout: timeout, log: testLog(), stacks: ioutil.Discard, exit: func(code int) { exited <- code }}
+	w.Start(make(chan struct{}))
+	defer w.Stop()
+	for i := 0; i < 5; i++ {
+		time.Sleep(timeout / 2)
+		w.Run(nil, zerolog.ErrorLevel, "Serve tunnel error")
+	}
+	select {
+	case <-exited:
+		t.Fatal("watchdog exited while connections were being retried")
+	default:
+	}
+}
+
+func TestWatchdogStopsOnShutdown(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	exited := make(chan int, 1)
+	graceShutdownC := make(chan struct{})
+	w := &watchdog{timeout: timeout, log: testLog(), stacks: ioutil.Discard, exit: func(code int) { exited <- code }}
+	w.Start(graceShutdownC)
+	close(graceShutdownC)
+	select {
+	case <-exited:
+		t.Fatal("watchdog exited during the graceful shutdown")
+	case <-time.After(4 * timeout):
+	}
+}
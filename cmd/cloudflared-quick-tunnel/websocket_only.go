@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// websocketOnly answers requests that aren't a WebSocket upgrade with 426 Upgrade Required, for a backend
+// that only speaks WebSocket and mishandles the plain requests of crawlers and probes.
+func websocketOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebsocketUpgrade(r) {
+			w.Header().Set("Connection", "Upgrade")
+			w.Header().Set("Upgrade", "websocket")
+			w.WriteHeader(http.StatusUpgradeRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWebsocketUpgrade reports whether r is a GET that asks to upgrade to WebSocket, as RFC 6455 describes it.
+func isWebsocketUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") &&
+		httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "websocket") &&
+		r.Header.Get("Sec-WebSocket-Key") != ""
+}
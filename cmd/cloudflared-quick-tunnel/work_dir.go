@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/logger"
+)
+
+// Files a tunnel keeps in --work-dir, and what they are named when their flag isn't set. Relative paths
+// given for them are resolved against the work directory, so tunnels sharing a config don't collide.
+var workDirFiles = []struct {
+	flag        string
+	defaultName string
+}{
+	{flag: "credentials", defaultName: "credentials.json"},
+	{flag: "pidfile", defaultName: "tunnel.pid"},
+	{flag: "state-file"},
+	{flag: logger.LogFileFlag},
+	{flag: "trace-on-error"},
+	{flag: "access-log"},
+	{flag: "callback-once-file"},
+	{flag: "url-history-file"},
+}
+
+// applyWorkDir creates --work-dir and points the tunnel's files into it.
+func applyWorkDir(c *cli.Context) error {
+	dir := c.String("work-dir")
+	if dir == "" {
+		if c.Bool("delete-on-exit") {
+			return errors.New("--delete-on-exit requires --work-dir")
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create --work-dir")
+	}
+	for _, file := range workDirFiles {
+		path := c.String(file.flag)
+		if !c.IsSet(file.flag) && file.defaultName != "" {
+			path = file.defaultName
+		}
+		if path == "" || path == "-" || filepath.IsAbs(path) {
+			continue
+		}
+		if err := c.Set(file.flag, filepath.Join(dir, path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanWorkDir removes the files the tunnel kept in --work-dir, then the directory if nothing else is
+// left in it. Anything else in the directory is not the tunnel's to delete.
+func cleanWorkDir(c *cli.Context, log *zerolog.Logger) {
+	dir := c.String("work-dir")
+	for _, file := range workDirFiles {
+		path := c.String(file.flag)
+		if path == "" || filepath.Dir(path) != filepath.Clean(dir) {
+			continue
+		}
+		if file.flag == "credentials" && c.Bool("readonly-credentials") {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Msgf("Failed to remove %s", path)
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		log.Warn().Msgf("Kept --work-dir %s: %v", dir, err)
+		return
+	}
+	log.Info().Msgf("Removed --work-dir %s", dir)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkDirFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tunnel")
+	c := runContext(t, "--work-dir", dir, "--delete-on-exit", "--readonly-credentials", "--logfile", "tunnel.log", "--access-log", "-",
+		"--callback-once-file", "callback-once", "--url-history-file", "url-history")
+	if err := applyWorkDir(c); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"credentials":        filepath.Join(dir, "credentials.json"),
+		"pidfile":            filepath.Join(dir, "tunnel.pid"),
+		"logfile":            filepath.Join(dir, "tunnel.log"),
+		"access-log":         "-",
+		"callback-once-file": filepath.Join(dir, "callback-once"),
+		"url-history-file":   filepath.Join(dir, "url-history"),
+	}
+	for flag, path := range want {
+		if got := c.String(flag); got != path {
+			t.Errorf("--%s = %s, want %s", flag, got, path)
+		}
+		if path != "-" {
+			if err := ioutil.WriteFile(path, []byte("x"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cleanWorkDir(c, testLog())
+	// --readonly-credentials keeps the credentials, and with them the directory
+	for flag, path := range want {
+		_, err := os.Stat(path)
+		if kept := err == nil; path != "-" && kept != (flag == "credentials") {
+			t.Errorf("--%s kept %v after cleanWorkDir", flag, kept)
+		}
+	}
+}
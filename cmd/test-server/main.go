@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 )
@@ -8,6 +11,7 @@ import (
 func main() {
 	http.HandleFunc("/ping", PingServer)
 	http.HandleFunc("/callback", CallbackServer)
+	http.HandleFunc("/tunnel", QuickServiceServer)
 	http.ListenAndServe(":8080", nil)
 }
 
@@ -22,3 +26,31 @@ func CallbackServer(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write([]byte("success"))
 }
+
+// QuickServiceServer stands in for trycloudflare.com, so the run flow can be tried with
+// --quick-service http://localhost:8080 --dry-run. The tunnel it returns can't connect to the edge.
+func QuickServiceServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := make([]byte, 16)
+	secret := make([]byte, 32)
+	rand.Read(id)
+	rand.Read(secret)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	tunnelID := fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"result": map[string]interface{}{
+			"id":          tunnelID,
+			"name":        "qt-" + tunnelID[:8],
+			"hostname":    tunnelID[:8] + ".test-server.localhost",
+			"account_tag": "test-server",
+			"secret":      secret,
+		},
+		"errors": []interface{}{},
+	})
+}